@@ -0,0 +1,166 @@
+package spz
+
+import (
+	"context"
+	"math"
+
+	"github.com/flywave/go-spz/internal/cancelcheck"
+)
+
+// ViewMode selects how BakeSH chooses view directions
+type ViewMode int
+
+const (
+	// ViewsSphere samples view directions uniformly over the sphere
+	ViewsSphere ViewMode = iota
+	// ViewsHemisphere samples viewers uniformly over the hemisphere around Up
+	ViewsHemisphere
+	// ViewsCameras looks at every splat from each of the camera positions
+	ViewsCameras
+)
+
+// defaultBakeSamples is the number of directions used when ViewSet.Samples is 0
+const defaultBakeSamples = 64
+
+// ViewSet describes the viewpoints over which BakeSH averages the SH color
+type ViewSet struct {
+	Mode ViewMode
+	// Samples is the number of directions for the sphere and hemisphere modes
+	Samples int
+	// Up is the hemisphere axis; viewers are on the side Up points to
+	Up [3]float32
+	// Cameras are the viewer positions for ViewsCameras
+	Cameras [][3]float32
+}
+
+// fibonacciDirections returns n nearly uniform unit vectors on the sphere, or
+// on the hemisphere around +Z when hemisphere is set
+func fibonacciDirections(n int, hemisphere bool) [][3]float64 {
+	golden := math.Pi * (3 - math.Sqrt(5))
+	dirs := make([][3]float64, n)
+	for i := range n {
+		var z float64
+		if hemisphere {
+			z = 1 - (float64(i)+0.5)/float64(n)
+		} else {
+			z = 1 - 2*(float64(i)+0.5)/float64(n)
+		}
+		r := math.Sqrt(math.Max(0, 1-z*z))
+		phi := golden * float64(i)
+		dirs[i] = [3]float64{r * math.Cos(phi), r * math.Sin(phi), z}
+	}
+	return dirs
+}
+
+// rotateFromZ rotates a vector so that +Z maps to the unit vector up
+func rotateFromZ(v, up [3]float64) [3]float64 {
+	// Orthonormal basis (t, b, up)
+	t := [3]float64{1, 0, 0}
+	if math.Abs(up[0]) > 0.9 {
+		t = [3]float64{0, 1, 0}
+	}
+	b := [3]float64{up[1]*t[2] - up[2]*t[1], up[2]*t[0] - up[0]*t[2], up[0]*t[1] - up[1]*t[0]}
+	bl := math.Sqrt(b[0]*b[0] + b[1]*b[1] + b[2]*b[2])
+	b = [3]float64{b[0] / bl, b[1] / bl, b[2] / bl}
+	t = [3]float64{b[1]*up[2] - b[2]*up[1], b[2]*up[0] - b[0]*up[2], b[0]*up[1] - b[1]*up[0]}
+
+	var out [3]float64
+	for i := range 3 {
+		out[i] = v[0]*t[i] + v[1]*b[i] + v[2]*up[i]
+	}
+	return out
+}
+
+// BakeSH evaluates the SH color of every splat over a set of views, averages
+// the results into ColorR/G/B and returns a degree-0 copy of the data. The
+// baked colors are in the domain of decoded colors and snapped to the values
+// the writer stores exactly, so writing and reading the result keeps them.
+func BakeSH(data *SpzData, views ViewSet) (*SpzData, error) {
+	return BakeSHContext(context.Background(), data, views)
+}
+
+// BakeSHContext is BakeSH with cancellation: it returns the context error
+// once ctx is canceled
+func BakeSHContext(ctx context.Context, data *SpzData, views ViewSet) (*SpzData, error) {
+	var dirs [][3]float64
+	switch views.Mode {
+	case ViewsSphere, ViewsHemisphere:
+		n := views.Samples
+		if n <= 0 {
+			n = defaultBakeSamples
+		}
+		if views.Mode == ViewsSphere {
+			dirs = fibonacciDirections(n, false)
+		} else {
+			up := normalizeDir(views.Up)
+			if views.Up == [3]float32{} {
+				up = [3]float64{0, 1, 0}
+			}
+			for _, v := range fibonacciDirections(n, true) {
+				// The view direction points from the viewer towards the splat
+				w := rotateFromZ(v, up)
+				dirs = append(dirs, [3]float64{-w[0], -w[1], -w[2]})
+			}
+		}
+	case ViewsCameras:
+		if len(views.Cameras) == 0 {
+			return nil, &SpzError{"Invalid view set: no camera positions"}
+		}
+	default:
+		return nil, &SpzError{"Invalid view set: unknown mode"}
+	}
+
+	out := &SpzData{
+		Magic:          data.Magic,
+		Version:        data.Version,
+		NumPoints:      data.NumPoints,
+		ShDegree:       0,
+		FractionalBits: data.FractionalBits,
+		Flags:          data.Flags,
+		Reserved:       data.Reserved,
+		Data:           make([]*SplatData, len(data.Data)),
+		Metadata:       data.Metadata,
+	}
+
+	var basis [15]float64
+	for i, d := range data.Data {
+		if err := cancelcheck.Check(ctx, i); err != nil {
+			return nil, err
+		}
+		degree := min(SplatSHDegree(d), data.ShDegree)
+
+		var sum [3]float64
+		count := 0
+		accumulate := func(dir [3]float64) {
+			rgb := evalSH(d, degree, dir, basis[:])
+			for c := range 3 {
+				sum[c] += math.Max(0, rgb[c])
+			}
+			count++
+		}
+		if views.Mode == ViewsCameras {
+			for _, cam := range views.Cameras {
+				accumulate(normalizeDir([3]float32{d.PositionX - cam[0], d.PositionY - cam[1], d.PositionZ - cam[2]}))
+			}
+		} else {
+			for _, dir := range dirs {
+				accumulate(dir)
+			}
+		}
+
+		baked := *d
+		baked.ColorR = bakedColor(sum[0] / float64(count))
+		baked.ColorG = bakedColor(sum[1] / float64(count))
+		baked.ColorB = bakedColor(sum[2] / float64(count))
+		baked.SH1, baked.SH2, baked.SH3 = nil, nil, nil
+		out.Data[i] = &baked
+	}
+
+	return out, nil
+}
+
+// bakedColor converts an averaged [0, 1] color to a decoded color byte
+// through the color mapping of the writer and the reader
+func bakedColor(v float64) uint8 {
+	return spzDecodeColor(spzEncodeColor(clipUint8Round(v * 255.0)))
+}
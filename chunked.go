@@ -0,0 +1,347 @@
+package spz
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"runtime"
+	"sync"
+)
+
+const (
+	// ChunkedVersion is the version of the chunked container layout
+	ChunkedVersion = 1
+	// ChunkedHeaderSize is the size of the container header preceding the
+	// chunk index
+	ChunkedHeaderSize = 16
+	// ChunkIndexEntrySize is the size of one chunk index entry
+	ChunkIndexEntrySize = 24
+	// DefaultChunkSize is the number of splats per chunk by default
+	DefaultChunkSize = 1 << 16
+)
+
+// chunkedMagic opens a chunked container. The container splits a cloud into
+// chunks, each a complete SPZ stream compressed on its own, so chunks can be
+// inflated concurrently or fetched individually with HTTP range requests:
+//
+//	"SPZC"
+//	uint32 version
+//	uint32 chunk count
+//	uint32 total number of splats
+//	chunk count × index entries: uint64 offset, uint64 size, uint32 first splat, uint32 splat count
+//	chunks
+//
+// The metadata of the cloud is stored in the first chunk.
+var chunkedMagic = []byte("SPZC")
+
+// ChunkInfo locates a chunk in a chunked container
+type ChunkInfo struct {
+	// Offset and Size locate the compressed chunk from the container start
+	Offset uint64
+	Size   uint64
+	// Start is the index of the first splat of the chunk and Count the
+	// number of splats it holds
+	Start uint32
+	Count uint32
+}
+
+// ChunkedOptions controls writing of chunked containers
+type ChunkedOptions struct {
+	// ChunkSize is the number of splats per chunk; 0 selects DefaultChunkSize
+	ChunkSize int
+	// Encode holds the options used to encode each chunk
+	Encode *EncodeOptions
+}
+
+// IsChunked reports whether bts starts a chunked container
+func IsChunked(bts []byte) bool {
+	return bytes.HasPrefix(bts, chunkedMagic)
+}
+
+// ParseChunkIndex parses the index of a chunked container. bts must hold at
+// least the header and the index, ChunkedHeaderSize + count ×
+// ChunkIndexEntrySize bytes, but not necessarily the chunks.
+func ParseChunkIndex(bts []byte) ([]ChunkInfo, error) {
+	if len(bts) < ChunkedHeaderSize || !IsChunked(bts) {
+		return nil, &SpzError{"Invalid chunked SPZ: missing container header"}
+	}
+	if version := binary.LittleEndian.Uint32(bts[4:]); version != ChunkedVersion {
+		return nil, &SpzError{"Unsupported chunked SPZ version"}
+	}
+	count := uint64(binary.LittleEndian.Uint32(bts[8:]))
+	total := binary.LittleEndian.Uint32(bts[12:])
+	if uint64(len(bts)-ChunkedHeaderSize) < count*ChunkIndexEntrySize {
+		return nil, &SpzError{"Invalid chunked SPZ: truncated chunk index"}
+	}
+
+	chunks := make([]ChunkInfo, count)
+	next := uint32(0)
+	for i := range chunks {
+		e := bts[ChunkedHeaderSize+i*ChunkIndexEntrySize:]
+		chunks[i] = ChunkInfo{
+			Offset: binary.LittleEndian.Uint64(e),
+			Size:   binary.LittleEndian.Uint64(e[8:]),
+			Start:  binary.LittleEndian.Uint32(e[16:]),
+			Count:  binary.LittleEndian.Uint32(e[20:]),
+		}
+		if chunks[i].Start != next || uint64(next)+uint64(chunks[i].Count) > uint64(total) {
+			return nil, &SpzError{"Invalid chunked SPZ: inconsistent chunk ranges"}
+		}
+		next += chunks[i].Count
+	}
+	if next != total {
+		return nil, &SpzError{"Invalid chunked SPZ: inconsistent chunk ranges"}
+	}
+	return chunks, nil
+}
+
+// forEachParallel calls fn for every index in [0, n) on a bounded number of
+// goroutines and returns the first error. No further indices are dispatched
+// once ctx is canceled.
+func forEachParallel(ctx context.Context, n int, fn func(i int) error) error {
+	errs := make([]error, n)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(n, runtime.GOMAXPROCS(0)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = fn(i)
+			}
+		}()
+	}
+	for i := range n {
+		if ctx.Err() != nil {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncodeChunked encodes a cloud as a chunked container, compressing the
+// chunks in parallel
+func EncodeChunked(spzData *SpzData, opts *ChunkedOptions) ([]byte, error) {
+	return EncodeChunkedContext(context.Background(), spzData, opts)
+}
+
+// EncodeChunkedContext is EncodeChunked with cancellation: it returns the
+// context error once ctx is canceled
+func EncodeChunkedContext(ctx context.Context, spzData *SpzData, opts *ChunkedOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &ChunkedOptions{}
+	}
+	size := opts.ChunkSize
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+
+	n := len(spzData.Data)
+	count := max(1, (n+size-1)/size)
+	streams := make([][]byte, count)
+	err := forEachParallel(ctx, count, func(i int) error {
+		chunk := *spzData
+		chunk.Data = spzData.Data[i*size : min(n, (i+1)*size)]
+		chunk.NumPoints = uint32(len(chunk.Data))
+		if i > 0 {
+			chunk.Metadata = nil
+		}
+		var err error
+		streams[i], err = EncodeContext(ctx, &chunk, opts.Encode)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, ChunkedHeaderSize+count*ChunkIndexEntrySize)
+	out = append(out, chunkedMagic...)
+	out = binary.LittleEndian.AppendUint32(out, ChunkedVersion)
+	out = binary.LittleEndian.AppendUint32(out, uint32(count))
+	out = binary.LittleEndian.AppendUint32(out, uint32(n))
+	offset := uint64(ChunkedHeaderSize + count*ChunkIndexEntrySize)
+	for i, s := range streams {
+		out = binary.LittleEndian.AppendUint64(out, offset)
+		out = binary.LittleEndian.AppendUint64(out, uint64(len(s)))
+		out = binary.LittleEndian.AppendUint32(out, uint32(min(n, i*size)))
+		out = binary.LittleEndian.AppendUint32(out, uint32(min(n, (i+1)*size)-min(n, i*size)))
+		offset += uint64(len(s))
+	}
+	for _, s := range streams {
+		out = append(out, s...)
+	}
+	return out, nil
+}
+
+// DecodeChunked decodes a chunked container, inflating and decoding the
+// chunks in parallel
+func DecodeChunked(bts []byte, opts *DecodeOptions) (*SpzData, error) {
+	return DecodeChunkedContext(context.Background(), bts, opts)
+}
+
+// DecodeChunkedContext is DecodeChunked with cancellation: it returns the
+// context error once ctx is canceled
+func DecodeChunkedContext(ctx context.Context, bts []byte, opts *DecodeOptions) (*SpzData, error) {
+	chunks, err := ParseChunkIndex(bts)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, &SpzError{"Invalid chunked SPZ: no chunks"}
+	}
+
+	decoded := make([]*SpzData, len(chunks))
+	err = forEachParallel(ctx, len(chunks), func(i int) error {
+		c := chunks[i]
+		stream, err := chunkStream(bts, c)
+		if err != nil {
+			return err
+		}
+		d, err := DecodeContext(ctx, stream, opts)
+		if err != nil {
+			return err
+		}
+		if d.NumPoints != c.Count {
+			return &SpzError{"Invalid chunked SPZ: chunk size does not match the index"}
+		}
+		decoded[i] = d
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	first := decoded[0]
+	out := *first
+	out.NumPoints = 0
+	out.Data = make([]*SplatData, 0, chunks[len(chunks)-1].Start+chunks[len(chunks)-1].Count)
+	for _, d := range decoded {
+		if d.Version != first.Version || d.ShDegree != first.ShDegree || d.FractionalBits != first.FractionalBits {
+			return nil, &SpzError{"Invalid chunked SPZ: chunks have different headers"}
+		}
+		out.Data = append(out.Data, d.Data...)
+		out.NumPoints += d.NumPoints
+	}
+	return &out, nil
+}
+
+// chunkStream returns the compressed stream of a chunk
+func chunkStream(bts []byte, c ChunkInfo) ([]byte, error) {
+	if c.Offset > uint64(len(bts)) || c.Size > uint64(len(bts))-c.Offset {
+		return nil, &SpzError{"Invalid chunked SPZ: chunk out of bounds"}
+	}
+	stream := bts[c.Offset : c.Offset+c.Size]
+	if IsChunked(stream) {
+		return nil, &SpzError{"Invalid chunked SPZ: nested container"}
+	}
+	return stream, nil
+}
+
+// inflateChunked decompresses the chunks of a container in parallel and
+// joins their sections into the uncompressed payload of a single stream
+// holding the whole cloud. SH codebook chunks are expanded to plain SH
+// records, and the metadata extension of the first chunk is kept.
+func inflateChunked(ctx context.Context, bts []byte) ([]byte, error) {
+	chunks, err := ParseChunkIndex(bts)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, &SpzError{"Invalid chunked SPZ: no chunks"}
+	}
+
+	layouts := make([]*spzLayout, len(chunks))
+	var ext []byte
+	err = forEachParallel(ctx, len(chunks), func(i int) error {
+		stream, err := chunkStream(bts, chunks[i])
+		if err != nil {
+			return err
+		}
+		ungzipDatas, err := decompressAuto(stream)
+		if err != nil {
+			return err
+		}
+		h, datas, chunkExt, err := splitSpzPayload(ungzipDatas)
+		if err != nil {
+			return err
+		}
+		if h.NumPoints != chunks[i].Count {
+			return &SpzError{"Invalid chunked SPZ: chunk size does not match the index"}
+		}
+		if layouts[i], err = newSpzLayout(datas, h); err != nil {
+			return err
+		}
+		if i == 0 {
+			ext = chunkExt
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	first := layouts[0].h
+	h := *first
+	h.NumPoints = 0
+	h.Flags &^= FlagSHCodebook
+	for _, l := range layouts {
+		if l.h.Version != first.Version || l.h.ShDegree != first.ShDegree || l.h.FractionalBits != first.FractionalBits {
+			return nil, &SpzError{"Invalid chunked SPZ: chunks have different headers"}
+		}
+		h.NumPoints += l.h.NumPoints
+	}
+
+	out := h.ToBytes()
+	for _, section := range []func(l *spzLayout) []byte{
+		func(l *spzLayout) []byte { return l.positions },
+		func(l *spzLayout) []byte { return l.alphas },
+		func(l *spzLayout) []byte { return l.colors },
+		func(l *spzLayout) []byte { return l.scales },
+		func(l *spzLayout) []byte { return l.rotations },
+	} {
+		for _, l := range layouts {
+			out = append(out, section(l)...)
+		}
+	}
+	for _, l := range layouts {
+		if l.shDim == 0 {
+			continue
+		}
+		for i := range int(l.h.NumPoints) {
+			out = append(out, l.sh(i)...)
+		}
+	}
+	return append(out, ext...), nil
+}
+
+// decompressPayload returns the uncompressed payload of an SPZ stream or of
+// a chunked container, joined as by inflateChunked
+func decompressPayload(bts []byte) ([]byte, error) {
+	if IsChunked(bts) {
+		return inflateChunked(context.Background(), bts)
+	}
+	return decompressAuto(bts)
+}
+
+// WriteSpzChunked writes a cloud to file as a chunked container
+func WriteSpzChunked(spzFile string, spzData *SpzData, opts *ChunkedOptions) error {
+	bts, err := EncodeChunked(spzData, opts)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(spzFile, bts, 0o644)
+}
@@ -0,0 +1,117 @@
+package spz
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newChunkedTestData(n int) *SpzData {
+	data := &SpzData{Magic: SPZ_MAGIC, Version: 3, NumPoints: uint32(n), ShDegree: 1, FractionalBits: 12}
+	for i := range n {
+		data.Data = append(data.Data, &SplatData{
+			PositionX: float32(i) / 8, PositionY: 1, PositionZ: -1,
+			RotationW: 255, RotationX: 128, RotationY: 128, RotationZ: 128,
+			ColorR: uint8(i), ColorA: uint8(255 - i),
+			SH1: []byte{128, 128, 128, 128, 128, 128, 128, 128, uint8(i)},
+		})
+	}
+	return data
+}
+
+// TestChunked tests writing, reading and random access of chunked containers
+func TestChunked(t *testing.T) {
+	data := newChunkedTestData(10)
+	data.Metadata = Metadata{"note": []byte("x")}
+	plain, err := Decode(mustEncode(t, data, nil))
+	assert.NoError(t, err)
+
+	bts, err := EncodeChunked(data, &ChunkedOptions{ChunkSize: 4})
+	assert.NoError(t, err)
+	assert.True(t, IsChunked(bts))
+
+	chunks, err := ParseChunkIndex(bts[:ChunkedHeaderSize+3*ChunkIndexEntrySize])
+	assert.NoError(t, err)
+	assert.Len(t, chunks, 3)
+	assert.Equal(t, uint32(8), chunks[2].Start)
+	assert.Equal(t, uint32(2), chunks[2].Count)
+	_, err = ParseChunkIndex(bts[:ChunkedHeaderSize+2*ChunkIndexEntrySize])
+	assert.Error(t, err)
+
+	// A chunk is a plain SPZ stream on its own
+	c := chunks[1]
+	chunk, err := Decode(bts[c.Offset : c.Offset+c.Size])
+	assert.NoError(t, err)
+	assert.Equal(t, plain.Data[4:8], chunk.Data)
+
+	decoded, err := DecodeWithOptions(bts, &DecodeOptions{Metadata: true})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(10), decoded.NumPoints)
+	assert.Equal(t, plain.Data, decoded.Data)
+	assert.Equal(t, data.Metadata, decoded.Metadata)
+
+	file := filepath.Join(t.TempDir(), "chunked.spz")
+	assert.NoError(t, WriteSpzChunked(file, data, nil))
+	decoded, err = ReadSpz(file)
+	assert.NoError(t, err)
+	assert.Equal(t, plain.Data, decoded.Data)
+
+	empty, err := EncodeChunked(&SpzData{Magic: SPZ_MAGIC, Version: 2, FractionalBits: 12}, nil)
+	assert.NoError(t, err)
+	decoded, err = Decode(empty)
+	assert.NoError(t, err)
+	assert.Empty(t, decoded.Data)
+
+	// Chunked containers are joined by the payload-level APIs
+	bts, err = EncodeChunked(data, &ChunkedOptions{ChunkSize: 4, Encode: &EncodeOptions{Checksum: true}})
+	assert.NoError(t, err)
+	hash, err := ContentHash(bts)
+	assert.NoError(t, err)
+	plainHash, err := ContentHash(mustEncode(t, data, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, plainHash, hash)
+
+	report, err := Verify(bts)
+	assert.NoError(t, err)
+	assert.Equal(t, "chunked", report.Codec)
+	assert.True(t, report.Valid)
+	assert.Len(t, report.Chunks, 3)
+	assert.Equal(t, hash, report.Computed.SHA256)
+
+	header, payload, err := DecompressSpzWithOptions(bts, &DecodeOptions{Metadata: true})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(10), header.NumPoints)
+	assert.Equal(t, data.Metadata["note"], header.Metadata["note"])
+	splats, err := DecodeRange(payload, header, 3, 6, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, plain.Data[3:6], splats)
+
+	file = filepath.Join(t.TempDir(), "mapped.spz")
+	assert.NoError(t, os.WriteFile(file, bts, 0o644))
+	m, err := OpenMapped(file)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, m.Len())
+	splat, err := m.Splat(9)
+	assert.NoError(t, err)
+	assert.Equal(t, plain.Data[9], splat)
+	assert.NoError(t, m.Close())
+
+	// Codebook chunks are expanded when joined
+	bts, err = EncodeChunked(data, &ChunkedOptions{ChunkSize: 4, Encode: &EncodeOptions{SHCodebookSize: 2}})
+	assert.NoError(t, err)
+	decoded, err = DecodeWithOptions(bts, &DecodeOptions{SHCodebook: true})
+	assert.NoError(t, err)
+	header, payload, err = DecompressSpz(bts)
+	assert.NoError(t, err)
+	assert.Zero(t, header.Flags&FlagSHCodebook)
+	splats, err = DecodeRange(payload, header, 0, 10, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, decoded.Data, splats)
+
+	// Chunks pointing outside the container are rejected
+	bts[ChunkedHeaderSize+8] = 0xff
+	_, err = Decode(bts)
+	assert.Error(t, err)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	spz "github.com/flywave/go-spz"
+)
+
+// runDiff compares two SPZ files. It exits with 0 if they are equal within
+// tolerances, 1 if they differ and 2 on errors, like diff(1).
+func runDiff(args []string) int {
+	defaults := spz.DefaultDiffOptions()
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the diff as JSON")
+	mode := fs.String("mode", "index", "splat pairing: index or nearest")
+	radius := fs.Float64("radius", defaults.MatchRadius, "match radius for nearest mode")
+	position := fs.Float64("position", defaults.Position, "position tolerance")
+	scale := fs.Float64("scale", defaults.Scale, "log scale tolerance")
+	rotation := fs.Float64("rotation", defaults.Rotation, "rotation tolerance in degrees")
+	opacity := fs.Float64("opacity", defaults.Opacity, "opacity tolerance in 8-bit units")
+	color := fs.Float64("color", defaults.Color, "color tolerance in 8-bit units")
+	sh := fs.Float64("sh", defaults.SH, "SH coefficient tolerance")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: spz diff [flags] a.spz b.spz")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	opts := spz.DiffOptions{
+		MatchRadius: *radius,
+		Position:    *position,
+		Scale:       *scale,
+		Rotation:    *rotation,
+		Opacity:     *opacity,
+		Color:       *color,
+		SH:          *sh,
+	}
+	switch *mode {
+	case "index":
+		opts.Mode = spz.DiffIndexAligned
+	case "nearest":
+		opts.Mode = spz.DiffNearestNeighbour
+	default:
+		fmt.Fprintf(os.Stderr, "spz diff: unknown mode %q\n", *mode)
+		return 2
+	}
+
+	// Both files are read in full, SH codebook payloads included
+	decode := &spz.DecodeOptions{SHCodebook: true}
+	a, err := spz.ReadSpzWithOptions(fs.Arg(0), decode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "spz diff: %s: %v\n", fs.Arg(0), err)
+		return 2
+	}
+	b, err := spz.ReadSpzWithOptions(fs.Arg(1), decode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "spz diff: %s: %v\n", fs.Arg(1), err)
+		return 2
+	}
+
+	result := spz.Diff(a, b, opts)
+	if *asJSON {
+		bts, err := result.ToJSON()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "spz diff: %v\n", err)
+			return 2
+		}
+		fmt.Println(string(bts))
+	} else {
+		fmt.Print(result.Summary())
+	}
+
+	if !result.Equal() {
+		return 1
+	}
+	return 0
+}
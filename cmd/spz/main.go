@@ -0,0 +1,40 @@
+// Command spz inspects, compares and verifies SPZ files.
+//
+// Usage:
+//
+//	spz diff [flags] a.spz b.spz
+//	spz verify [flags] file.spz...
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// command is a subcommand taking its own arguments and returning the exit code
+type command func(args []string) int
+
+var commands = map[string]command{
+	"diff":   runDiff,
+	"verify": runVerify,
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: spz <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  diff    compare two SPZ files")
+	fmt.Fprintln(os.Stderr, "  verify  check the integrity of SPZ files")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	os.Exit(cmd(os.Args[2:]))
+}
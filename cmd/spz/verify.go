@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	spz "github.com/flywave/go-spz"
+)
+
+// runVerify checks the integrity of SPZ files. It exits with 0 if all files
+// are valid, 1 if a checksum does not match and 2 on errors.
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the reports as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: spz verify [flags] file.spz...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	code := 0
+	for _, file := range fs.Args() {
+		bts, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "spz verify: %v\n", err)
+			code = 2
+			continue
+		}
+		report, err := spz.VerifyWithOptions(bts, &spz.DecodeOptions{SHCodebook: true})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "spz verify: %s: %v\n", file, err)
+			code = 2
+			continue
+		}
+		if !report.Valid {
+			code = max(code, 1)
+		}
+
+		if *asJSON {
+			out, err := report.ToJSON()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "spz verify: %v\n", err)
+				return 2
+			}
+			fmt.Println(string(out))
+			continue
+		}
+
+		status := "ok"
+		switch {
+		case !report.Valid:
+			status = "CHECKSUM MISMATCH"
+		case report.Embedded == nil:
+			status = "ok (no embedded checksum)"
+		}
+		fmt.Printf("%s: %s\n", file, status)
+		fmt.Printf("  codec:  %s\n", report.Codec)
+		if report.GzipCRC32 != "" {
+			fmt.Printf("  gzip:   crc32 %s\n", report.GzipCRC32)
+		}
+		fmt.Printf("  sha256: %s\n", report.Computed.SHA256)
+		fmt.Printf("  crc32:  %s\n", report.Computed.CRC32)
+	}
+	return code
+}
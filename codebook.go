@@ -0,0 +1,178 @@
+package spz
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/flywave/go-spz/internal/cancelcheck"
+)
+
+const (
+	// FlagSHCodebook marks payloads whose SH section is replaced by the
+	// experimental codebook extension. Their header version also carries
+	// shCodebookVersion, which readers that do not support it refuse.
+	FlagSHCodebook uint8 = 0x80
+	// shCodebookVersion is set in the header version of SH codebook payloads.
+	// The payload size alone does not reveal the codebook: a section of
+	// exactly NumPoints × shDim bytes reads as plain SH data.
+	shCodebookVersion uint32 = 1 << 8
+	// MaxSHCodebookSize is the largest palette addressable by the 16-bit indices
+	MaxSHCodebookSize = 1 << 16
+	// defaultCodebookIterations is the number of k-means iterations by default
+	defaultCodebookIterations = 10
+)
+
+// spzBaseDataSize returns the size of the position, alpha, color, scale and
+// rotation sections
+func spzBaseDataSize(h *SpzData) int {
+	rotationSize := 3
+	if h.Version >= 3 {
+		rotationSize = 4
+	}
+	return int(h.NumPoints) * (9 + 1 + 3 + 3 + rotationSize)
+}
+
+// encodeSHCodebook replaces the SH section of an encoded payload with a
+// k-means palette and per-splat indices:
+//
+//	uint32 entry count K
+//	K × shDim bytes of palette entries
+//	NumPoints × uint16 palette indices
+func encodeSHCodebook(ctx context.Context, payload []byte, h *SpzData, size, iterations int) ([]byte, error) {
+	if size > MaxSHCodebookSize {
+		return nil, &SpzError{"Invalid SH codebook size: at most 65536 entries are supported"}
+	}
+	if iterations <= 0 {
+		iterations = defaultCodebookIterations
+	}
+
+	dim := shCoeffsForDegree[min(h.ShDegree, 3)] * 3
+	n := len(h.Data)
+	shStart := len(payload) - n*dim
+	shs := payload[shStart:]
+
+	vecs := make([]float32, len(shs))
+	for i, b := range shs {
+		vecs[i] = float32(b)
+	}
+	centroids, assign, err := kMeans(ctx, vecs, dim, n, size, iterations)
+	if err != nil {
+		return nil, err
+	}
+	k := len(centroids) / dim
+
+	out := make([]byte, 0, shStart+4+k*dim+n*2)
+	out = append(out, payload[:shStart]...)
+	binary.LittleEndian.PutUint32(out[4:8], h.Version|shCodebookVersion)
+	out[14] |= FlagSHCodebook
+	out = binary.LittleEndian.AppendUint32(out, uint32(k))
+	for _, v := range centroids {
+		out = append(out, clipUint8Round(float64(v)))
+	}
+	for _, a := range assign {
+		out = binary.LittleEndian.AppendUint16(out, a)
+	}
+	return out, nil
+}
+
+// kMeans clusters n vectors of size dim into at most k centroids with Lloyd's
+// algorithm, returning the centroids and the index assigned to each vector.
+// ctx is checked before every iteration.
+func kMeans(ctx context.Context, vecs []float32, dim, n, k, iterations int) ([]float32, []uint16, error) {
+	assign := make([]uint16, n)
+	if n <= k {
+		centroids := make([]float32, len(vecs))
+		copy(centroids, vecs)
+		for i := range assign {
+			assign[i] = uint16(i)
+		}
+		return centroids, assign, nil
+	}
+
+	// Deterministic initialization from evenly spaced samples
+	centroids := make([]float32, k*dim)
+	for c := range k {
+		src := c * n / k
+		copy(centroids[c*dim:(c+1)*dim], vecs[src*dim:(src+1)*dim])
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	chunk := (n + workers - 1) / workers
+	sums := make([]float64, k*dim)
+	counts := make([]int, k)
+	for iter := range iterations {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		var wg sync.WaitGroup
+		moved := make([]bool, workers)
+		for w := range workers {
+			start, end := w*chunk, min(n, (w+1)*chunk)
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := start; i < end; i++ {
+					if cancelcheck.Check(ctx, i-start) != nil {
+						return
+					}
+					best := nearestCentroid(vecs[i*dim:(i+1)*dim], centroids, dim, k)
+					if iter == 0 || assign[i] != best {
+						assign[i] = best
+						moved[w] = true
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		anyMoved := false
+		for _, m := range moved {
+			anyMoved = anyMoved || m
+		}
+		if !anyMoved {
+			break
+		}
+
+		clear(sums)
+		clear(counts)
+		for i := range n {
+			c := int(assign[i])
+			counts[c]++
+			for j := range dim {
+				sums[c*dim+j] += float64(vecs[i*dim+j])
+			}
+		}
+		for c := range k {
+			// Empty clusters keep their previous centroid
+			if counts[c] == 0 {
+				continue
+			}
+			for j := range dim {
+				centroids[c*dim+j] = float32(sums[c*dim+j] / float64(counts[c]))
+			}
+		}
+	}
+
+	return centroids, assign, nil
+}
+
+func nearestCentroid(v, centroids []float32, dim, k int) uint16 {
+	best, bestDist := 0, math.MaxFloat64
+	for c := range k {
+		dist := 0.0
+		for j := range dim {
+			d := float64(v[j] - centroids[c*dim+j])
+			dist += d * d
+		}
+		if dist < bestDist {
+			best, bestDist = c, dist
+		}
+	}
+	return uint16(best)
+}
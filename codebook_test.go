@@ -0,0 +1,93 @@
+package spz
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSHCodebook tests the experimental SH codebook encode mode
+func TestSHCodebook(t *testing.T) {
+	data := &SpzData{Magic: SPZ_MAGIC, Version: 3, ShDegree: 3, FractionalBits: 12}
+	for i := range 200 {
+		sh2 := make([]byte, 24)
+		sh3 := make([]byte, 21)
+		// Two distinct SH patterns with small jitter
+		base := byte(64)
+		if i%2 == 1 {
+			base = 192
+		}
+		for j := range sh2 {
+			sh2[j] = base + byte(i%3)
+		}
+		for j := range sh3 {
+			sh3[j] = 255 - base
+		}
+		data.Data = append(data.Data, &SplatData{
+			PositionX: float32(i), RotationW: 255, RotationX: 128, RotationY: 128, RotationZ: 128,
+			ColorA: 255, SH2: sh2, SH3: sh3,
+		})
+	}
+	data.NumPoints = uint32(len(data.Data))
+
+	bts, err := EncodeWithOptions(data, &EncodeOptions{Compressor: NoneCompressor{}, SHCodebookSize: 4})
+	assert.NoError(t, err)
+	assert.Less(t, len(bts), HeaderSizeSpz+int(data.NumPoints)*(20+45))
+
+	// Readers without codebook support reject the payload
+	_, err = Decode(bts)
+	assert.Error(t, err)
+	assert.Equal(t, uint32(3)|shCodebookVersion, binary.LittleEndian.Uint32(bts[4:8]))
+
+	decoded, err := DecodeWithOptions(bts, &DecodeOptions{SHCodebook: true})
+	assert.NoError(t, err)
+	assert.Equal(t, data.NumPoints, decoded.NumPoints)
+	assert.NotZero(t, decoded.Flags&FlagSHCodebook)
+
+	standard, err := Decode(mustEncode(t, data, NoneCompressor{}))
+	assert.NoError(t, err)
+	for i := range decoded.Data {
+		assert.Len(t, decoded.Data[i].SH2, 24)
+		assert.Len(t, decoded.Data[i].SH3, 21)
+		assert.InDelta(t, int(standard.Data[i].SH2[0]), int(decoded.Data[i].SH2[0]), 16)
+		assert.Equal(t, standard.Data[i].SH3, decoded.Data[i].SH3)
+		assert.Equal(t, standard.Data[i].PositionX, decoded.Data[i].PositionX)
+	}
+}
+
+// TestSHCodebookCollidingSize tests a codebook section exactly as long as
+// the plain SH section, 4 + 41×45 + 2×43 = 43×45 bytes, which only the
+// header version tells apart
+func TestSHCodebookCollidingSize(t *testing.T) {
+	data := &SpzData{Magic: SPZ_MAGIC, Version: 3, ShDegree: 3, FractionalBits: 12}
+	for i := range 43 {
+		sh2 := make([]byte, 24)
+		sh3 := make([]byte, 21)
+		for j := range sh2 {
+			sh2[j] = byte(i * 5)
+		}
+		data.Data = append(data.Data, &SplatData{RotationW: 255, RotationX: 128, RotationY: 128, RotationZ: 128, SH2: sh2, SH3: sh3})
+	}
+	data.NumPoints = uint32(len(data.Data))
+
+	bts, err := EncodeWithOptions(data, &EncodeOptions{Compressor: NoneCompressor{}, SHCodebookSize: 41})
+	assert.NoError(t, err)
+	assert.Len(t, bts, HeaderSizeSpz+43*(20+45))
+	assert.Equal(t, uint32(41), binary.LittleEndian.Uint32(bts[HeaderSizeSpz+43*20:]))
+
+	// A standard reader refuses versions above 3 before sizing the payload
+	assert.Greater(t, binary.LittleEndian.Uint32(bts[4:8]), uint32(3))
+	_, err = Decode(bts)
+	assert.Error(t, err)
+
+	decoded, err := DecodeWithOptions(bts, &DecodeOptions{SHCodebook: true})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), decoded.Version)
+	assert.Len(t, decoded.Data, 43)
+
+	// Without the version bit the flag alone is refused
+	binary.LittleEndian.PutUint32(bts[4:8], 3)
+	_, err = ParseSpzHeader(bts)
+	assert.Error(t, err)
+}
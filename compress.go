@@ -0,0 +1,345 @@
+package spz
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+// Compressor compresses and decompresses the SPZ payload (header and data)
+type Compressor interface {
+	// Name returns the registry name of the codec
+	Name() string
+	// Compress compresses the uncompressed payload
+	Compress(bts []byte) ([]byte, error)
+	// Decompress restores the uncompressed payload
+	Decompress(bts []byte) ([]byte, error)
+	// Match reports whether the stream starts with the codec's magic bytes
+	Match(head []byte) bool
+}
+
+// GzipCompressor compresses with gzip at the given level
+type GzipCompressor struct {
+	Level int
+}
+
+// NewGzipCompressor creates a gzip compressor, e.g. gzip.BestSpeed for previews
+// or gzip.BestCompression for archival
+func NewGzipCompressor(level int) *GzipCompressor {
+	return &GzipCompressor{Level: level}
+}
+
+func (c *GzipCompressor) Name() string { return "gzip" }
+
+func (c *GzipCompressor) Compress(bts []byte) ([]byte, error) {
+	return compressGzip(bts, c.Level)
+}
+
+func (c *GzipCompressor) Decompress(bts []byte) ([]byte, error) {
+	return decompressGzip(bts)
+}
+
+func (c *GzipCompressor) newReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func (c *GzipCompressor) Match(head []byte) bool {
+	return len(head) >= 2 && head[0] == 0x1f && head[1] == 0x8b
+}
+
+// DeflateCompressor compresses with raw DEFLATE (RFC 1951). Raw DEFLATE has
+// no magic bytes, so it is never detected; readers select it with
+// DecodeOptions.Compressor.
+type DeflateCompressor struct {
+	Level int
+}
+
+// NewDeflateCompressor creates a raw DEFLATE compressor
+func NewDeflateCompressor(level int) *DeflateCompressor {
+	return &DeflateCompressor{Level: level}
+}
+
+func (c *DeflateCompressor) Name() string { return "deflate" }
+
+func (c *DeflateCompressor) Compress(bts []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, c.Level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(bts); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *DeflateCompressor) Decompress(bts []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(bts))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (c *DeflateCompressor) newReader(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
+
+func (c *DeflateCompressor) Match(head []byte) bool {
+	return false
+}
+
+// ZlibCompressor compresses with zlib (RFC 1950)
+type ZlibCompressor struct {
+	Level int
+}
+
+// NewZlibCompressor creates a zlib compressor
+func NewZlibCompressor(level int) *ZlibCompressor {
+	return &ZlibCompressor{Level: level}
+}
+
+func (c *ZlibCompressor) Name() string { return "zlib" }
+
+func (c *ZlibCompressor) Compress(bts []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := zlib.NewWriterLevel(&buf, c.Level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(bts); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *ZlibCompressor) Decompress(bts []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(bts))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (c *ZlibCompressor) newReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+func (c *ZlibCompressor) Match(head []byte) bool {
+	// CM must be 8 (deflate) and the header checksum must be a multiple of 31
+	return len(head) >= 2 && head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0
+}
+
+// NoneCompressor stores the payload uncompressed, useful for debugging
+type NoneCompressor struct{}
+
+func (c NoneCompressor) Name() string { return "none" }
+
+func (c NoneCompressor) Compress(bts []byte) ([]byte, error) {
+	return bts, nil
+}
+
+func (c NoneCompressor) Decompress(bts []byte) ([]byte, error) {
+	return bts, nil
+}
+
+func (c NoneCompressor) Match(head []byte) bool {
+	return len(head) >= 4 && binary.LittleEndian.Uint32(head) == SPZ_MAGIC
+}
+
+// payloadReader is implemented by the built-in codecs that expand their
+// input, so that decompression can stop at the size the payload declares
+type payloadReader interface {
+	newReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// DefaultCompressor is used by WriteSpz and Encode
+var DefaultCompressor Compressor = NewGzipCompressor(gzip.DefaultCompression)
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = []Compressor{
+		DefaultCompressor,
+		NewZlibCompressor(zlib.DefaultCompression),
+		NoneCompressor{},
+		NewDeflateCompressor(flate.DefaultCompression),
+	}
+)
+
+// RegisterCompressor registers a codec for lookup by name and for detection
+// on read. A codec registered under an existing name replaces it.
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+
+	for i := range compressors {
+		if compressors[i].Name() == c.Name() {
+			compressors[i] = c
+			return
+		}
+	}
+	// Custom codecs are matched before the built-in ones
+	compressors = append([]Compressor{c}, compressors...)
+}
+
+// unregisterCompressor removes the codec with the given name, so tests can
+// undo RegisterCompressor
+func unregisterCompressor(name string) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+
+	for i := range compressors {
+		if compressors[i].Name() == name {
+			compressors = append(compressors[:i:i], compressors[i+1:]...)
+			return
+		}
+	}
+}
+
+// LookupCompressor returns the registered codec with the given name
+func LookupCompressor(name string) (Compressor, bool) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+
+	for _, c := range compressors {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// DetectCompressor returns the codec whose magic bytes match the stream, or
+// nil if none does
+func DetectCompressor(bts []byte) Compressor {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+
+	for _, c := range compressors {
+		if c.Match(bts) {
+			return c
+		}
+	}
+	return nil
+}
+
+// decompressAuto detects the codec and returns the uncompressed payload
+func decompressAuto(bts []byte) ([]byte, error) {
+	if c := DetectCompressor(bts); c != nil {
+		return decompress(c, bts)
+	}
+
+	// Uncompressed payloads start with the SPZ magic
+	if len(bts) >= 4 && binary.LittleEndian.Uint32(bts) == SPZ_MAGIC {
+		return bts, nil
+	}
+	return nil, errUnknownCompression
+}
+
+// decompressWith decompresses with the codec selected by the caller, or
+// detects it when c is nil
+func decompressWith(c Compressor, bts []byte) ([]byte, error) {
+	if c == nil {
+		return decompressAuto(bts)
+	}
+	return decompress(c, bts)
+}
+
+// errUnknownCompression rejects streams that match no codec and are not
+// uncompressed payloads
+var errUnknownCompression = &SpzError{"Invalid SPZ file: unknown compression"}
+
+// errPayloadTooLarge rejects decompressed payloads longer than their header
+// allows, e.g. decompression bombs
+var errPayloadTooLarge = &SpzError{"Invalid SPZ file: decompressed payload exceeds the size declared by its header"}
+
+// decompress runs a codec, bounding the output of the built-in ones by
+// readPayload
+func decompress(c Compressor, bts []byte) ([]byte, error) {
+	pr, ok := c.(payloadReader)
+	if !ok {
+		return c.Decompress(bts)
+	}
+	r, err := pr.newReader(bytes.NewReader(bts))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readPayload(r)
+}
+
+// readPayload reads an uncompressed SPZ payload. It reads no more than the
+// header, the SH codebook size and the metadata extension length declare, so
+// a small stream cannot expand without bound. An invalid header is
+// rejected; payloads that end early are returned as read, for
+// splitSpzPayload and newSpzLayout to reject with their own errors.
+func readPayload(r io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	// readTo reads until the payload is size bytes long and reports whether
+	// it got there
+	readTo := func(size int64) (bool, error) {
+		n := size - int64(buf.Len())
+		if n <= 0 {
+			return true, nil
+		}
+		_, err := io.CopyN(&buf, r, n)
+		if err == io.EOF {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	if ok, err := readTo(HeaderSizeSpz); !ok {
+		return buf.Bytes(), err
+	}
+	h, err := ParseSpzHeader(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	size := int64(HeaderSizeSpz) + int64(spzBaseDataSize(h))
+	dim := int64(shCoeffsForDegree[h.ShDegree] * 3)
+	if h.Flags&FlagSHCodebook != 0 && dim > 0 {
+		if ok, err := readTo(size + 4); !ok {
+			return buf.Bytes(), err
+		}
+		k := int64(binary.LittleEndian.Uint32(buf.Bytes()[size:]))
+		if k > MaxSHCodebookSize {
+			return buf.Bytes(), nil
+		}
+		size += 4 + k*dim + int64(h.NumPoints)*2
+	} else {
+		size += int64(h.NumPoints) * dim
+	}
+	if ok, err := readTo(size); !ok {
+		return buf.Bytes(), err
+	}
+
+	// An optional metadata extension declares its own length
+	if ok, err := readTo(size + metadataHeaderSize); !ok {
+		return buf.Bytes(), err
+	}
+	if ext := buf.Bytes()[size:]; bytes.HasPrefix(ext, metadataMagic) {
+		size += metadataHeaderSize + int64(binary.LittleEndian.Uint32(ext[8:]))
+		if ok, err := readTo(size); !ok {
+			return buf.Bytes(), err
+		}
+	}
+
+	// Reading to the end also verifies the codec checksum
+	if ok, err := readTo(int64(buf.Len()) + 1); ok {
+		return nil, errPayloadTooLarge
+	} else if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,164 @@
+package spz
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newCompressTestData() *SpzData {
+	return &SpzData{
+		Magic:          SPZ_MAGIC,
+		Version:        3,
+		NumPoints:      2,
+		ShDegree:       1,
+		FractionalBits: 12,
+		Data: []*SplatData{
+			{
+				PositionX: 1.5, PositionY: 2.5, PositionZ: 3.5,
+				ScaleX: 0.1, ScaleY: 0.2, ScaleZ: 0.3,
+				RotationW: 128, RotationX: 138, RotationY: 148, RotationZ: 158,
+				ColorR: 255, ColorG: 128, ColorB: 64, ColorA: 200,
+				SH1: []byte{100, 110, 120, 130, 140, 150, 160, 170, 180},
+			},
+			{
+				PositionX: -1.5, PositionY: -2.5, PositionZ: -3.5,
+				ScaleX: 0.4, ScaleY: 0.5, ScaleZ: 0.6,
+				RotationW: 130, RotationX: 140, RotationY: 150, RotationZ: 160,
+				ColorR: 64, ColorG: 128, ColorB: 255, ColorA: 180,
+				SH1: []byte{90, 100, 110, 120, 130, 140, 150, 160, 170},
+			},
+		},
+	}
+}
+
+// xorCompressor is a toy codec used to test registration
+type xorCompressor struct{}
+
+func (xorCompressor) Name() string { return "xor" }
+
+func (xorCompressor) Compress(bts []byte) ([]byte, error) {
+	out := []byte{'X', 'O', 'R', '!'}
+	for _, b := range bts {
+		out = append(out, b^0x5a)
+	}
+	return out, nil
+}
+
+func (xorCompressor) Decompress(bts []byte) ([]byte, error) {
+	out := make([]byte, 0, len(bts)-4)
+	for _, b := range bts[4:] {
+		out = append(out, b^0x5a)
+	}
+	return out, nil
+}
+
+func (xorCompressor) Match(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("XOR!"))
+}
+
+// TestCompressors tests round trips through each built-in codec
+func TestCompressors(t *testing.T) {
+	codecs := []Compressor{
+		NewGzipCompressor(gzip.BestSpeed),
+		NewGzipCompressor(gzip.BestCompression),
+		NewDeflateCompressor(gzip.DefaultCompression),
+		NewZlibCompressor(zlib.BestCompression),
+		NoneCompressor{},
+	}
+
+	want, err := Decode(mustEncode(t, newCompressTestData(), DefaultCompressor))
+	assert.NoError(t, err)
+
+	for _, c := range codecs {
+		bts := mustEncode(t, newCompressTestData(), c)
+
+		// Raw DEFLATE has no magic bytes and must be selected
+		opts := &DecodeOptions{}
+		if c.Name() == "deflate" {
+			_, err := Decode(bts)
+			assert.EqualError(t, err, "Invalid SPZ file: unknown compression")
+			opts.Compressor = c
+		}
+		got, err := DecodeWithOptions(bts, opts)
+		assert.NoError(t, err, "codec %s", c.Name())
+		assert.Equal(t, want, got, "codec %s", c.Name())
+		header, _, err := DecompressSpzWithOptions(bts, opts)
+		assert.NoError(t, err, "codec %s", c.Name())
+		assert.Equal(t, want.NumPoints, header.NumPoints)
+	}
+
+	// Uncompressed payloads are read as they are, other bytes are rejected
+	payload, err := decompressAuto(mustEncode(t, newCompressTestData(), NoneCompressor{}))
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(SPZ_MAGIC), binary.LittleEndian.Uint32(payload))
+	_, err = decompressAuto([]byte("not an spz file"))
+	assert.ErrorIs(t, err, errUnknownCompression)
+}
+
+// TestRegisterCompressor tests detection of a custom codec
+func TestRegisterCompressor(t *testing.T) {
+	RegisterCompressor(xorCompressor{})
+	t.Cleanup(func() { unregisterCompressor("xor") })
+
+	c, ok := LookupCompressor("xor")
+	assert.True(t, ok)
+
+	bts := mustEncode(t, newCompressTestData(), c)
+	assert.Equal(t, "xor", DetectCompressor(bts).Name())
+
+	got, err := Decode(bts)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), got.NumPoints)
+}
+
+// TestUnregisterCompressor tests that removing a custom codec restores the
+// built-in detection
+func TestUnregisterCompressor(t *testing.T) {
+	RegisterCompressor(xorCompressor{})
+	unregisterCompressor("xor")
+
+	_, ok := LookupCompressor("xor")
+	assert.False(t, ok)
+	bts := mustEncode(t, newCompressTestData(), nil)
+	assert.Equal(t, "gzip", DetectCompressor(bts).Name())
+}
+
+func mustEncode(t testing.TB, data *SpzData, c Compressor) []byte {
+	t.Helper()
+	bts, err := EncodeWithCompressor(data, c)
+	assert.NoError(t, err)
+	return bts
+}
+
+// TestDecompressLimit tests that payloads longer than their header declares
+// are rejected without being read to the end, that shorter ones are left
+// for the layout to reject, and that invalid headers are rejected
+func TestDecompressLimit(t *testing.T) {
+	files := malformedCorpus(t)
+	_, err := Decode(files["decompression_bomb.spz"])
+	assert.ErrorIs(t, err, errPayloadTooLarge)
+	_, err = Decode(files["header_only.spz"])
+	assert.EqualError(t, err, "Invalid SPZ data: incorrect data size")
+
+	header := (&SpzData{Magic: SPZ_MAGIC, Version: 7, FractionalBits: 12}).ToBytes()
+	payload, err := readPayload(bytes.NewReader(header))
+	assert.EqualError(t, err, "Unsupported SPZ version: 7")
+	assert.Nil(t, payload)
+	gz, err := DefaultCompressor.Compress(header)
+	assert.NoError(t, err)
+	_, err = Decode(gz)
+	assert.EqualError(t, err, "Unsupported SPZ version: 7")
+
+	data := newCompressTestData()
+	data.Metadata = Metadata{"k": []byte("v")}
+	for _, c := range []Compressor{DefaultCompressor, NewZlibCompressor(zlib.BestSpeed), NewDeflateCompressor(gzip.BestSpeed)} {
+		got, err := DecodeWithOptions(mustEncode(t, data, c), &DecodeOptions{Metadata: true, Compressor: c})
+		assert.NoError(t, err, c.Name())
+		assert.Equal(t, data.Metadata, got.Metadata, c.Name())
+	}
+}
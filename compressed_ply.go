@@ -0,0 +1,202 @@
+package spz
+
+import (
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// compressedPlyChunkSize is the number of splats sharing one chunk's min/max ranges
+const compressedPlyChunkSize = 256
+
+// ReadCompressedPly reads a PlayCanvas / SuperSplat compressed PLY file.
+// Coordinates are kept as stored in the source file.
+func ReadCompressedPly(file string) (*SpzData, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return DecodeCompressedPly(f)
+}
+
+// DecodeCompressedPly decodes a PlayCanvas compressed PLY stream, where
+// positions, scales and colors are quantized against per-chunk min/max ranges
+func DecodeCompressedPly(r io.Reader) (*SpzData, error) {
+	elements, err := readPly(r)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := findPlyElement(elements, "chunk")
+	vertices := findPlyElement(elements, "vertex")
+	if chunks == nil || vertices == nil {
+		return nil, &SpzError{"Invalid compressed PLY: missing chunk or vertex element"}
+	}
+	if chunks.count*compressedPlyChunkSize < vertices.count {
+		return nil, &SpzError{"Invalid compressed PLY: not enough chunks"}
+	}
+
+	chunkNames := []string{
+		"min_x", "min_y", "min_z", "max_x", "max_y", "max_z",
+		"min_scale_x", "min_scale_y", "min_scale_z", "max_scale_x", "max_scale_y", "max_scale_z",
+	}
+	chunkProps := make([]*plyProperty, len(chunkNames))
+	for i, name := range chunkNames {
+		if chunkProps[i] = chunks.property(name); chunkProps[i] == nil {
+			return nil, &SpzError{"Invalid compressed PLY: missing chunk property " + name}
+		}
+	}
+	// Newer files also quantize colors per chunk
+	var colorProps []*plyProperty
+	for _, name := range []string{"min_r", "min_g", "min_b", "max_r", "max_g", "max_b"} {
+		if p := chunks.property(name); p != nil {
+			colorProps = append(colorProps, p)
+		}
+	}
+
+	var packed [4]*plyProperty
+	for i, name := range []string{"packed_position", "packed_rotation", "packed_scale", "packed_color"} {
+		if packed[i] = vertices.property(name); packed[i] == nil || packed[i].typ != "uint" && packed[i].typ != "uint32" {
+			return nil, &SpzError{"Invalid compressed PLY: missing vertex property " + name}
+		}
+	}
+
+	// Optional SH bands, stored channel-major as in standard PLY files
+	var shDegree uint8
+	var shProps []*plyProperty
+	shElement := findPlyElement(elements, "sh")
+	if shElement != nil && shElement.count == vertices.count {
+		for i := 0; ; i++ {
+			p := shElement.property("f_rest_" + strconv.Itoa(i))
+			if p == nil {
+				break
+			}
+			shProps = append(shProps, p)
+		}
+		for deg := 3; deg > 0; deg-- {
+			if len(shProps) >= shCoeffsForDegree[deg]*3 {
+				shDegree = uint8(deg)
+				break
+			}
+		}
+	}
+
+	spzData := &SpzData{
+		Magic:          SPZ_MAGIC,
+		Version:        3,
+		NumPoints:      uint32(vertices.count),
+		ShDegree:       shDegree,
+		FractionalBits: 12,
+		Data:           make([]*SplatData, vertices.count),
+	}
+
+	coeffs := shCoeffsForDegree[shDegree]
+	var chunk [12]float64
+	var colorRange [6]float64
+	for i := range vertices.count {
+		if i%compressedPlyChunkSize == 0 {
+			c := i / compressedPlyChunkSize
+			for j, p := range chunkProps {
+				chunk[j] = chunks.value(c, p)
+			}
+			if len(colorProps) == 6 {
+				for j, p := range colorProps {
+					colorRange[j] = chunks.value(c, p)
+				}
+			}
+		}
+
+		d := &SplatData{}
+		px, py, pz := unpack111011(vertices.uint32Value(i, packed[0]))
+		d.PositionX = float32(lerp(chunk[0], chunk[3], px))
+		d.PositionY = float32(lerp(chunk[1], chunk[4], py))
+		d.PositionZ = float32(lerp(chunk[2], chunk[5], pz))
+
+		d.ScaleX, d.ScaleY, d.ScaleZ = unpackChunkScale(vertices.uint32Value(i, packed[2]), chunk[6:12])
+
+		setSplatRotation(d, unpackRotation2101010(vertices.uint32Value(i, packed[1])))
+
+		color := vertices.uint32Value(i, packed[3])
+		r := float64(color>>24) / 255
+		g := float64((color>>16)&0xff) / 255
+		b := float64((color>>8)&0xff) / 255
+		if len(colorProps) == 6 {
+			r = lerp(colorRange[0], colorRange[3], r)
+			g = lerp(colorRange[1], colorRange[4], g)
+			b = lerp(colorRange[2], colorRange[5], b)
+		}
+		d.ColorR = clipUint8Round(r * 255)
+		d.ColorG = clipUint8Round(g * 255)
+		d.ColorB = clipUint8Round(b * 255)
+		d.ColorA = uint8(color & 0xff)
+
+		if shDegree > 0 {
+			// Convert channel-major f_rest to coefficient-major RGB triples
+			channelStride := len(shProps) / 3
+			sh := make([]byte, coeffs*3)
+			for k := range coeffs {
+				for c := range 3 {
+					v := shElement.value(i, shProps[c*channelStride+k])
+					sh[k*3+c] = encodeSplatSH(decodeCompressedPlySH(v))
+				}
+			}
+			setSplatSH(d, shDegree, sh)
+		}
+
+		spzData.Data[i] = d
+	}
+
+	return spzData, nil
+}
+
+func lerp(lo, hi, t float64) float64 {
+	return lo + (hi-lo)*t
+}
+
+// unpack111011 unpacks three unit floats stored with 11, 10 and 11 bits
+func unpack111011(v uint32) (float64, float64, float64) {
+	return float64(v>>21) / 2047, float64((v>>11)&0x3ff) / 1023, float64(v&0x7ff) / 2047
+}
+
+// unpackChunkScale unpacks log scales quantized against a chunk's scale range
+func unpackChunkScale(v uint32, scaleRange []float64) (float32, float32, float32) {
+	sx, sy, sz := unpack111011(v)
+	return float32(lerp(scaleRange[0], scaleRange[3], sx)),
+		float32(lerp(scaleRange[1], scaleRange[4], sy)),
+		float32(lerp(scaleRange[2], scaleRange[5], sz))
+}
+
+// unpackRotation2101010 unpacks a "smallest three" quaternion with a 2-bit
+// index of the largest component and three 10-bit components, returning
+// (w, x, y, z)
+func unpackRotation2101010(v uint32) [4]float64 {
+	norm := math.Sqrt2
+	a := (float64((v>>20)&0x3ff)/1023 - 0.5) * norm
+	b := (float64((v>>10)&0x3ff)/1023 - 0.5) * norm
+	c := (float64(v&0x3ff)/1023 - 0.5) * norm
+	m := math.Sqrt(math.Max(0, 1-(a*a+b*b+c*c)))
+
+	// The packed order is (w, x, y, z) with the largest component omitted
+	switch v >> 30 {
+	case 0:
+		return [4]float64{m, a, b, c}
+	case 1:
+		return [4]float64{a, m, b, c}
+	case 2:
+		return [4]float64{a, b, m, c}
+	default:
+		return [4]float64{a, b, c, m}
+	}
+}
+
+// decodeCompressedPlySH converts an 8-bit SH value of a compressed PLY to its coefficient
+func decodeCompressedPlySH(v float64) float64 {
+	n := 0.0
+	if v != 0 {
+		n = (v + 0.5) / 256
+	}
+	return (n - 0.5) * 8
+}
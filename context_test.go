@@ -0,0 +1,59 @@
+package spz
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestContextCancel tests that the context variants stop with the context
+// error and otherwise match the plain functions
+func TestContextCancel(t *testing.T) {
+	data := newChunkedTestData(10)
+	bts := mustEncode(t, data, nil)
+
+	ctx := context.Background()
+	decoded, err := DecodeContext(ctx, bts, nil)
+	assert.NoError(t, err)
+	plain, err := Decode(bts)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, decoded)
+	encoded, err := EncodeContext(ctx, data, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, bts, encoded)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err = DecodeContext(canceled, bts, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = EncodeContext(canceled, data, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = EncodeContext(canceled, data, &EncodeOptions{SHCodebookSize: 4})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	chunked, err := EncodeChunked(data, &ChunkedOptions{ChunkSize: 4})
+	assert.NoError(t, err)
+	_, err = DecodeContext(canceled, chunked, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = EncodeChunkedContext(canceled, data, &ChunkedOptions{ChunkSize: 4})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = BakeSHContext(canceled, data, ViewSet{Mode: ViewsSphere})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = StatsContext(canceled, data)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = DiffContext(canceled, data, data, DiffOptions{Mode: DiffNearestNeighbour})
+	assert.ErrorIs(t, err, context.Canceled)
+	before := *data.Data[0]
+	assert.ErrorIs(t, ChangeSHDegreeContext(canceled, data, 0, true), context.Canceled)
+	assert.Equal(t, plain.ShDegree, data.ShDegree)
+	assert.Equal(t, before, *data.Data[0])
+
+	file := filepath.Join(t.TempDir(), "canceled.spz")
+	assert.ErrorIs(t, WriteSpzContext(canceled, file, data, nil), context.Canceled)
+	assert.NoFileExists(t, file)
+}
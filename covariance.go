@@ -0,0 +1,168 @@
+package spz
+
+import "math"
+
+// jacobiSweeps bounds the number of Jacobi rotation sweeps in eigenSymmetric3
+const jacobiSweeps = 32
+
+// SplatCovariance returns the 3x3 covariance R·S·Sᵀ·Rᵀ of a splat
+func SplatCovariance(d *SplatData) [3][3]float64 {
+	q, _ := splatRotation(d)
+	r := quatToMatrix(q)
+	s := splatScale(d)
+
+	var cov [3][3]float64
+	for i := range 3 {
+		for j := range 3 {
+			sum := 0.0
+			for k := range 3 {
+				sum += r[i][k] * s[k] * s[k] * r[j][k]
+			}
+			cov[i][j] = sum
+		}
+	}
+	return cov
+}
+
+// PackCovariance packs the upper triangle of a covariance as xx, xy, xz, yy, yz, zz
+func PackCovariance(cov [3][3]float64) [6]float32 {
+	return [6]float32{
+		float32(cov[0][0]), float32(cov[0][1]), float32(cov[0][2]),
+		float32(cov[1][1]), float32(cov[1][2]), float32(cov[2][2]),
+	}
+}
+
+// UnpackCovariance expands a packed upper triangle to a symmetric 3x3 matrix
+func UnpackCovariance(p [6]float32) [3][3]float64 {
+	return [3][3]float64{
+		{float64(p[0]), float64(p[1]), float64(p[2])},
+		{float64(p[1]), float64(p[3]), float64(p[4])},
+		{float64(p[2]), float64(p[4]), float64(p[5])},
+	}
+}
+
+// SetCovariance sets the scale and rotation of a splat from a covariance by
+// eigendecomposition. Eigenvalues are clamped to the smallest encodable scale.
+func SetCovariance(d *SplatData, cov [3][3]float64) {
+	values, vectors := eigenSymmetric3(cov)
+
+	// The eigenvectors are the columns of the rotation; keep it right-handed
+	if det3(vectors) < 0 {
+		for i := range 3 {
+			vectors[i][2] = -vectors[i][2]
+		}
+	}
+
+	minVariance := math.Exp(2 * -10.0)
+	var logScale [3]float32
+	for i := range 3 {
+		logScale[i] = float32(0.5 * math.Log(math.Max(values[i], minVariance)))
+	}
+	d.ScaleX, d.ScaleY, d.ScaleZ = logScale[0], logScale[1], logScale[2]
+
+	setSplatRotation(d, matrixToQuat(vectors))
+}
+
+// Covariances returns the packed covariance of every splat as a columnar
+// buffer of 6 floats per splat
+func Covariances(data *SpzData) []float32 {
+	out := make([]float32, 6*len(data.Data))
+	for i, d := range data.Data {
+		p := PackCovariance(SplatCovariance(d))
+		copy(out[i*6:i*6+6], p[:])
+	}
+	return out
+}
+
+// ApplyCovariances sets the scale and rotation of every splat from a columnar
+// buffer of packed covariances as returned by Covariances
+func ApplyCovariances(data *SpzData, cov []float32) error {
+	if len(cov) != 6*len(data.Data) {
+		return &SpzError{"Invalid covariance buffer: expected 6 floats per splat"}
+	}
+	for i, d := range data.Data {
+		SetCovariance(d, UnpackCovariance([6]float32(cov[i*6:i*6+6])))
+	}
+	return nil
+}
+
+// eigenSymmetric3 computes the eigenvalues and eigenvectors (as matrix
+// columns) of a symmetric 3x3 matrix with the cyclic Jacobi method
+func eigenSymmetric3(m [3][3]float64) ([3]float64, [3][3]float64) {
+	a := m
+	v := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+	for range jacobiSweeps {
+		off := a[0][1]*a[0][1] + a[0][2]*a[0][2] + a[1][2]*a[1][2]
+		if off < 1e-30 {
+			break
+		}
+		for p := 0; p < 2; p++ {
+			for q := p + 1; q < 3; q++ {
+				if a[p][q] == 0 {
+					continue
+				}
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+
+				// a = Jᵀ·a·J
+				for k := range 3 {
+					akp, akq := a[k][p], a[k][q]
+					a[k][p] = c*akp - s*akq
+					a[k][q] = s*akp + c*akq
+				}
+				for k := range 3 {
+					apk, aqk := a[p][k], a[q][k]
+					a[p][k] = c*apk - s*aqk
+					a[q][k] = s*apk + c*aqk
+				}
+				// v = v·J
+				for k := range 3 {
+					vkp, vkq := v[k][p], v[k][q]
+					v[k][p] = c*vkp - s*vkq
+					v[k][q] = s*vkp + c*vkq
+				}
+			}
+		}
+	}
+
+	return [3]float64{a[0][0], a[1][1], a[2][2]}, v
+}
+
+func det3(m [3][3]float64) float64 {
+	return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+}
+
+// matrixToQuat converts a rotation matrix to a normalized (w, x, y, z)
+// quaternion with a non-negative w
+func matrixToQuat(m [3][3]float64) [4]float64 {
+	var q [4]float64
+	trace := m[0][0] + m[1][1] + m[2][2]
+	switch {
+	case trace > 0:
+		s := 0.5 / math.Sqrt(trace+1)
+		q = [4]float64{0.25 / s, (m[2][1] - m[1][2]) * s, (m[0][2] - m[2][0]) * s, (m[1][0] - m[0][1]) * s}
+	case m[0][0] > m[1][1] && m[0][0] > m[2][2]:
+		s := 2 * math.Sqrt(1+m[0][0]-m[1][1]-m[2][2])
+		q = [4]float64{(m[2][1] - m[1][2]) / s, 0.25 * s, (m[0][1] + m[1][0]) / s, (m[0][2] + m[2][0]) / s}
+	case m[1][1] > m[2][2]:
+		s := 2 * math.Sqrt(1+m[1][1]-m[0][0]-m[2][2])
+		q = [4]float64{(m[0][2] - m[2][0]) / s, (m[0][1] + m[1][0]) / s, 0.25 * s, (m[1][2] + m[2][1]) / s}
+	default:
+		s := 2 * math.Sqrt(1+m[2][2]-m[0][0]-m[1][1])
+		q = [4]float64{(m[1][0] - m[0][1]) / s, (m[0][2] + m[2][0]) / s, (m[1][2] + m[2][1]) / s, 0.25 * s}
+	}
+
+	qlen := math.Sqrt(q[0]*q[0] + q[1]*q[1] + q[2]*q[2] + q[3]*q[3])
+	if q[0] < 0 {
+		qlen = -qlen
+	}
+	return [4]float64{q[0] / qlen, q[1] / qlen, q[2] / qlen, q[3] / qlen}
+}
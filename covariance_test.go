@@ -0,0 +1,38 @@
+package spz
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCovarianceRoundTrip tests covariance computation and its eigendecomposition
+func TestCovarianceRoundTrip(t *testing.T) {
+	data := &SpzData{
+		Data: []*SplatData{
+			{ScaleX: 0, ScaleY: -1, ScaleZ: -2, RotationW: 255, RotationX: 128, RotationY: 128, RotationZ: 128},
+			{ScaleX: 0.5, ScaleY: -0.5, ScaleZ: -3, RotationW: 200, RotationX: 160, RotationY: 100, RotationZ: 140},
+			{ScaleX: -1, ScaleY: -1, ScaleZ: -1, RotationW: 140, RotationX: 220, RotationY: 90, RotationZ: 60},
+		},
+	}
+
+	// Identity rotation gives a diagonal covariance of squared scales
+	cov := SplatCovariance(data.Data[0])
+	assert.InDelta(t, 1.0, cov[0][0], 1e-9)
+	assert.InDelta(t, 0.1353352832, cov[1][1], 1e-9)
+	assert.InDelta(t, 0.0, cov[0][1], 1e-9)
+
+	packed := Covariances(data)
+	assert.Len(t, packed, 18)
+
+	restored := &SpzData{Data: []*SplatData{{}, {}, {}}}
+	assert.NoError(t, ApplyCovariances(restored, packed))
+
+	// Quaternions are quantized to 8 bits, so compare the covariances loosely
+	again := Covariances(restored)
+	for i := range packed {
+		assert.InDelta(t, packed[i], again[i], 0.01, "component %d", i)
+	}
+
+	assert.Error(t, ApplyCovariances(restored, packed[:5]))
+}
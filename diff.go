@@ -0,0 +1,406 @@
+package spz
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/flywave/go-spz/internal/cancelcheck"
+)
+
+// DiffMode selects how splats of two clouds are paired
+type DiffMode int
+
+const (
+	// DiffIndexAligned pairs splats with the same index
+	DiffIndexAligned DiffMode = iota
+	// DiffNearestNeighbour pairs splats of the two clouds one to one within
+	// MatchRadius, closest pairs first, so that duplicated or removed splats
+	// are reported as unmatched
+	DiffNearestNeighbour
+)
+
+// DiffOptions holds the pairing mode and the per-attribute tolerances under
+// which paired splats are considered equal
+type DiffOptions struct {
+	Mode DiffMode
+	// MatchRadius bounds the pairing distance in nearest-neighbour mode
+	MatchRadius float64
+
+	Position float64 // per-axis, in scene units
+	Scale    float64 // per-axis, logarithmic
+	Rotation float64 // in degrees
+	Opacity  float64 // 8-bit units
+	Color    float64 // 8-bit units
+	SH       float64 // decoded coefficient units
+}
+
+// DefaultDiffOptions returns index-aligned tolerances that absorb one
+// quantization step of the SPZ encoding
+func DefaultDiffOptions() DiffOptions {
+	return DiffOptions{
+		Mode:        DiffIndexAligned,
+		MatchRadius: 0.01,
+		Position:    1.0 / 4096,
+		Scale:       1.0 / 16,
+		Rotation:    1.0,
+		Opacity:     1,
+		Color:       2,
+		SH:          8.0 / 128,
+	}
+}
+
+// HeaderChange is a header field that differs between two clouds
+type HeaderChange struct {
+	Field string `json:"field"`
+	A     uint32 `json:"a"`
+	B     uint32 `json:"b"`
+}
+
+// AttributeDiff counts paired splats whose attribute differs beyond tolerance
+type AttributeDiff struct {
+	Name      string  `json:"name"`
+	Differing int     `json:"differing"`
+	MaxDelta  float64 `json:"max_delta"`
+}
+
+// DiffResult describes the differences between two clouds
+type DiffResult struct {
+	Header     []HeaderChange  `json:"header,omitempty"`
+	NumPointsA int             `json:"num_points_a"`
+	NumPointsB int             `json:"num_points_b"`
+	Matched    int             `json:"matched"`
+	UnmatchedA int             `json:"unmatched_a"`
+	UnmatchedB int             `json:"unmatched_b"`
+	Attributes []AttributeDiff `json:"attributes"`
+	// DifferingSplats counts paired splats with at least one attribute beyond tolerance
+	DifferingSplats int `json:"differing_splats"`
+}
+
+// Equal reports whether the clouds match under the tolerances
+func (r *DiffResult) Equal() bool {
+	return len(r.Header) == 0 && r.UnmatchedA == 0 && r.UnmatchedB == 0 && r.DifferingSplats == 0
+}
+
+// ToJSON serializes the diff to indented JSON
+func (r *DiffResult) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// Summary returns a human readable description of the diff
+func (r *DiffResult) Summary() string {
+	if r.Equal() {
+		return "files are equal within tolerances\n"
+	}
+
+	var sb strings.Builder
+	for _, h := range r.Header {
+		fmt.Fprintf(&sb, "header %s: %d -> %d\n", h.Field, h.A, h.B)
+	}
+	fmt.Fprintf(&sb, "points: %d -> %d (%d matched, %d only in a, %d only in b)\n",
+		r.NumPointsA, r.NumPointsB, r.Matched, r.UnmatchedA, r.UnmatchedB)
+	for _, a := range r.Attributes {
+		if a.Differing > 0 {
+			fmt.Fprintf(&sb, "%s: %d splats differ (max delta %g)\n", a.Name, a.Differing, a.MaxDelta)
+		}
+	}
+	fmt.Fprintf(&sb, "%d of %d matched splats differ\n", r.DifferingSplats, r.Matched)
+	return sb.String()
+}
+
+// Diff compares the headers and splats of two clouds under the given options
+func Diff(a, b *SpzData, opts DiffOptions) *DiffResult {
+	result, _ := DiffContext(context.Background(), a, b, opts)
+	return result
+}
+
+// DiffContext is Diff with cancellation: it returns the context error once
+// ctx is canceled
+func DiffContext(ctx context.Context, a, b *SpzData, opts DiffOptions) (*DiffResult, error) {
+	result := &DiffResult{NumPointsA: len(a.Data), NumPointsB: len(b.Data)}
+
+	for _, f := range []HeaderChange{
+		{"version", a.Version, b.Version},
+		{"num_points", a.NumPoints, b.NumPoints},
+		{"sh_degree", uint32(a.ShDegree), uint32(b.ShDegree)},
+		{"fractional_bits", uint32(a.FractionalBits), uint32(b.FractionalBits)},
+		{"flags", uint32(a.Flags), uint32(b.Flags)},
+	} {
+		if f.A != f.B {
+			result.Header = append(result.Header, f)
+		}
+	}
+
+	pairs := make([]int, len(a.Data))
+	switch opts.Mode {
+	case DiffNearestNeighbour:
+		tree, err := newPointTree(ctx, b.Data, opts.MatchRadius)
+		if err != nil {
+			return nil, err
+		}
+		if pairs, err = tree.match(ctx, a.Data); err != nil {
+			return nil, err
+		}
+	default:
+		for i := range pairs {
+			pairs[i] = -1
+			if i < len(b.Data) {
+				pairs[i] = i
+			}
+		}
+	}
+
+	attrs := []AttributeDiff{{Name: "position"}, {Name: "scale"}, {Name: "rotation"}, {Name: "opacity"}, {Name: "color"}, {Name: "sh"}}
+	limits := []float64{opts.Position, opts.Scale, opts.Rotation, opts.Opacity, opts.Color, opts.SH}
+	shDegree := min(a.ShDegree, b.ShDegree, 3)
+	usedB := make([]bool, len(b.Data))
+
+	for i, j := range pairs {
+		if err := cancelcheck.Check(ctx, i); err != nil {
+			return nil, err
+		}
+		if j < 0 {
+			result.UnmatchedA++
+			continue
+		}
+		usedB[j] = true
+		result.Matched++
+
+		deltas := splatDeltas(a.Data[i], b.Data[j], shDegree)
+		differs := false
+		for k, delta := range deltas {
+			attrs[k].MaxDelta = math.Max(attrs[k].MaxDelta, delta)
+			if delta > limits[k] {
+				attrs[k].Differing++
+				differs = true
+			}
+		}
+		if differs {
+			result.DifferingSplats++
+		}
+	}
+	for _, used := range usedB {
+		if !used {
+			result.UnmatchedB++
+		}
+	}
+
+	result.Attributes = attrs
+	return result, nil
+}
+
+// splatDeltas returns the largest position, scale, rotation, opacity, color
+// and SH differences between two splats
+func splatDeltas(a, b *SplatData, shDegree uint8) [6]float64 {
+	var deltas [6]float64
+	deltas[0] = max(
+		math.Abs(float64(a.PositionX)-float64(b.PositionX)),
+		math.Abs(float64(a.PositionY)-float64(b.PositionY)),
+		math.Abs(float64(a.PositionZ)-float64(b.PositionZ)))
+	deltas[1] = max(
+		math.Abs(float64(a.ScaleX)-float64(b.ScaleX)),
+		math.Abs(float64(a.ScaleY)-float64(b.ScaleY)),
+		math.Abs(float64(a.ScaleZ)-float64(b.ScaleZ)))
+
+	deltas[2] = rotationAngleDegrees(a, b)
+
+	deltas[3] = math.Abs(float64(a.ColorA) - float64(b.ColorA))
+	deltas[4] = max(
+		math.Abs(float64(a.ColorR)-float64(b.ColorR)),
+		math.Abs(float64(a.ColorG)-float64(b.ColorG)),
+		math.Abs(float64(a.ColorB)-float64(b.ColorB)))
+
+	if shDegree > 0 {
+		sha, shb := splatSHBytes(a, shDegree), splatSHBytes(b, shDegree)
+		for k := range sha {
+			deltas[5] = math.Max(deltas[5], math.Abs(decodeSHByte(sha[k])-decodeSHByte(shb[k])))
+		}
+	}
+	return deltas
+}
+
+// pointTree is an implicit k-d tree over splat centers for radius-bounded
+// nearest-neighbour lookups: the median of each index range is the node
+// splitting it, on the x, y and z axes in turn. Splats are taken out of the
+// lookups once paired.
+type pointTree struct {
+	radius float64
+	perm   []int
+	splats []*SplatData
+	used   []bool
+	// free counts the unpaired splats of the range whose median is at each
+	// position of perm, so that exhausted ranges are skipped
+	free []int
+	pos  []int
+}
+
+func newPointTree(ctx context.Context, splats []*SplatData, radius float64) (*pointTree, error) {
+	if radius <= 0 {
+		radius = 1e-6
+	}
+	n := len(splats)
+	t := &pointTree{
+		radius: radius,
+		perm:   make([]int, n),
+		splats: splats,
+		used:   make([]bool, n),
+		free:   make([]int, n),
+		pos:    make([]int, n),
+	}
+	for i := range t.perm {
+		t.perm[i] = i
+	}
+	t.build(ctx, 0, n, 0)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for p, j := range t.perm {
+		t.pos[j] = p
+	}
+	return t, nil
+}
+
+// splatCoord returns the position of a splat on an axis
+func splatCoord(d *SplatData, axis int) float64 {
+	switch axis {
+	case 0:
+		return float64(d.PositionX)
+	case 1:
+		return float64(d.PositionY)
+	}
+	return float64(d.PositionZ)
+}
+
+// build sorts the range [lo, hi) on axis around its median and recurses on
+// both halves with the next axis. It gives up once ctx is canceled.
+func (t *pointTree) build(ctx context.Context, lo, hi, axis int) {
+	if lo >= hi || hi-lo >= cancelcheck.Interval && ctx.Err() != nil {
+		return
+	}
+	mid := (lo + hi) / 2
+	t.free[mid] = hi - lo
+	idx := t.perm[lo:hi]
+	sort.Slice(idx, func(i, j int) bool { return splatCoord(t.splats[idx[i]], axis) < splatCoord(t.splats[idx[j]], axis) })
+	t.build(ctx, lo, mid, (axis+1)%3)
+	t.build(ctx, mid+1, hi, (axis+1)%3)
+}
+
+// take removes tree splat j from the lookups
+func (t *pointTree) take(j int) {
+	t.used[j] = true
+	lo, hi := 0, len(t.perm)
+	for p := t.pos[j]; ; {
+		mid := (lo + hi) / 2
+		t.free[mid]--
+		if p == mid {
+			return
+		}
+		if p < mid {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+}
+
+// nearest returns the unpaired tree splat nearest to p within the radius,
+// or -1, and its squared distance
+func (t *pointTree) nearest(p [3]float64) (int, float64) {
+	best, dist := -1, t.radius*t.radius
+	t.search(0, len(t.perm), 0, p, &best, &dist)
+	return best, dist
+}
+
+func (t *pointTree) search(lo, hi, axis int, p [3]float64, best *int, bestDist *float64) {
+	if lo >= hi {
+		return
+	}
+	mid := (lo + hi) / 2
+	// Nothing is nearer than a coincident splat
+	if t.free[mid] == 0 || *best >= 0 && *bestDist == 0 {
+		return
+	}
+	j := t.perm[mid]
+	d := t.splats[j]
+	if !t.used[j] {
+		ex := float64(d.PositionX) - p[0]
+		ey := float64(d.PositionY) - p[1]
+		ez := float64(d.PositionZ) - p[2]
+		if dist := ex*ex + ey*ey + ez*ez; dist < *bestDist || dist == *bestDist && *best < 0 {
+			*best, *bestDist = j, dist
+		}
+	}
+
+	delta := p[axis] - splatCoord(d, axis)
+	next := (axis + 1) % 3
+	near, far := [2]int{lo, mid}, [2]int{mid + 1, hi}
+	if delta >= 0 {
+		near, far = far, near
+	}
+	t.search(near[0], near[1], next, p, best, bestDist)
+	if delta*delta <= *bestDist {
+		t.search(far[0], far[1], next, p, best, bestDist)
+	}
+}
+
+// treePair is a candidate pairing of a query splat with a tree splat
+type treePair struct {
+	a, b int
+	dist float64
+}
+
+// pairQueue orders candidate pairings by distance, then by query index
+type pairQueue []treePair
+
+func (q pairQueue) Len() int { return len(q) }
+func (q pairQueue) Less(i, j int) bool {
+	return q[i].dist < q[j].dist || q[i].dist == q[j].dist && q[i].a < q[j].a
+}
+func (q pairQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *pairQueue) Push(x any)   { *q = append(*q, x.(treePair)) }
+func (q *pairQueue) Pop() any {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+// match pairs every query splat with at most one tree splat within the
+// radius and vice versa, greedily by increasing distance. Each query splat
+// holds a single candidate, its nearest unpaired tree splat, which is looked
+// up again once another query splat takes it. It returns the tree index
+// paired with each query splat, or -1.
+func (t *pointTree) match(ctx context.Context, query []*SplatData) ([]int, error) {
+	pairs := make([]int, len(query))
+	points := make([][3]float64, len(query))
+	queue := make(pairQueue, 0, len(query))
+	for i, q := range query {
+		if err := cancelcheck.Check(ctx, i); err != nil {
+			return nil, err
+		}
+		pairs[i] = -1
+		points[i] = [3]float64{float64(q.PositionX), float64(q.PositionY), float64(q.PositionZ)}
+		if j, dist := t.nearest(points[i]); j >= 0 {
+			queue = append(queue, treePair{i, j, dist})
+		}
+	}
+	heap.Init(&queue)
+
+	for n := 0; queue.Len() > 0; n++ {
+		if err := cancelcheck.Check(ctx, n); err != nil {
+			return nil, err
+		}
+		c := heap.Pop(&queue).(treePair)
+		if !t.used[c.b] {
+			pairs[c.a] = c.b
+			t.take(c.b)
+		} else if j, dist := t.nearest(points[c.a]); j >= 0 {
+			heap.Push(&queue, treePair{c.a, j, dist})
+		}
+	}
+	return pairs, nil
+}
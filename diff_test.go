@@ -0,0 +1,138 @@
+package spz
+
+import (
+	"context"
+	"math/rand/v2"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDiff tests index-aligned and nearest-neighbour diffs
+func TestDiff(t *testing.T) {
+	a := newCompressTestData()
+	b := newCompressTestData()
+
+	result := Diff(a, b, DefaultDiffOptions())
+	assert.True(t, result.Equal())
+	assert.Equal(t, 2, result.Matched)
+
+	b.Data[1].ColorR += 10
+	b.Data[1].PositionX += 0.5
+	result = Diff(a, b, DefaultDiffOptions())
+	assert.False(t, result.Equal())
+	assert.Equal(t, 1, result.DifferingSplats)
+	assert.Equal(t, 1, result.Attributes[0].Differing)
+	assert.InDelta(t, 0.5, result.Attributes[0].MaxDelta, 1e-6)
+	assert.Equal(t, 1, result.Attributes[4].Differing)
+	assert.Contains(t, result.Summary(), "color: 1 splats differ")
+
+	// Reordered splats only match by position
+	b = newCompressTestData()
+	b.Data[0], b.Data[1] = b.Data[1], b.Data[0]
+	assert.False(t, Diff(a, b, DefaultDiffOptions()).Equal())
+	opts := DefaultDiffOptions()
+	opts.Mode = DiffNearestNeighbour
+	assert.True(t, Diff(a, b, opts).Equal())
+
+	// A splat without a counterpart in range is unmatched on both sides
+	b.Data[0].PositionZ += 1
+	result = Diff(a, b, opts)
+	assert.Equal(t, 1, result.UnmatchedA)
+	assert.Equal(t, 1, result.UnmatchedB)
+
+	// Duplicates pair with a single counterpart
+	b = newCompressTestData()
+	dup := *b.Data[0]
+	b.Data = append(b.Data, &dup, &dup)
+	b.NumPoints = 4
+	result = Diff(a, b, opts)
+	assert.Equal(t, 2, result.Matched)
+	assert.Equal(t, 0, result.UnmatchedA)
+	assert.Equal(t, 2, result.UnmatchedB)
+	result = Diff(b, a, opts)
+	assert.Equal(t, 2, result.Matched)
+	assert.Equal(t, 2, result.UnmatchedA)
+	assert.Equal(t, 0, result.UnmatchedB)
+
+	b = newCompressTestData()
+	b.NumPoints = 3
+	b.Data = append(b.Data, &SplatData{})
+	result = Diff(a, b, DefaultDiffOptions())
+	assert.Equal(t, []HeaderChange{{"num_points", 2, 3}}, result.Header)
+	assert.Equal(t, 1, result.UnmatchedB)
+}
+
+// newDiffCloud returns n splats at random positions in a cube of the given
+// size, or all at the origin when size is 0
+func newDiffCloud(n int, size float64, seed uint64) *SpzData {
+	r := rand.New(rand.NewPCG(seed, 0))
+	data := &SpzData{NumPoints: uint32(n)}
+	for range n {
+		data.Data = append(data.Data, &SplatData{
+			PositionX: float32(r.Float64() * size),
+			PositionY: float32(r.Float64() * size),
+			PositionZ: float32(r.Float64() * size),
+			RotationW: 255, RotationX: 128, RotationY: 128, RotationZ: 128,
+		})
+	}
+	return data
+}
+
+// TestDiffNearestMatch tests the nearest-neighbour pairing against the
+// greedy pairing of all candidate pairs, and on coincident splats
+func TestDiffNearestMatch(t *testing.T) {
+	a, b := newDiffCloud(500, 1, 1), newDiffCloud(400, 1, 2)
+	const radius = 0.05
+	tree, err := newPointTree(context.Background(), b.Data, radius)
+	assert.NoError(t, err)
+	pairs, err := tree.match(context.Background(), a.Data)
+	assert.NoError(t, err)
+
+	var all []treePair
+	for i, p := range a.Data {
+		for j, q := range b.Data {
+			ex, ey, ez := float64(p.PositionX-q.PositionX), float64(p.PositionY-q.PositionY), float64(p.PositionZ-q.PositionZ)
+			if dist := ex*ex + ey*ey + ez*ez; dist <= radius*radius {
+				all = append(all, treePair{i, j, dist})
+			}
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].dist < all[j].dist })
+	want := make([]int, len(a.Data))
+	for i := range want {
+		want[i] = -1
+	}
+	used := make([]bool, len(b.Data))
+	for _, c := range all {
+		if want[c.a] < 0 && !used[c.b] {
+			want[c.a], used[c.b] = c.b, true
+		}
+	}
+	assert.Equal(t, want, pairs)
+
+	// Coincident splats pair one to one
+	opts := DefaultDiffOptions()
+	opts.Mode = DiffNearestNeighbour
+	result := Diff(newDiffCloud(300, 0, 1), newDiffCloud(200, 0, 2), opts)
+	assert.Equal(t, 200, result.Matched)
+	assert.Equal(t, 100, result.UnmatchedA)
+	assert.Equal(t, 0, result.UnmatchedB)
+}
+
+func BenchmarkDiffNearestNeighbour(b *testing.B) {
+	opts := DefaultDiffOptions()
+	opts.Mode = DiffNearestNeighbour
+	for _, bc := range []struct {
+		name string
+		size float64
+	}{{"spread", 10}, {"coincident", 0}} {
+		x, y := newDiffCloud(50000, bc.size, 1), newDiffCloud(50000, bc.size, 2)
+		b.Run(bc.name, func(b *testing.B) {
+			for b.Loop() {
+				Diff(x, y, opts)
+			}
+		})
+	}
+}
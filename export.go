@@ -0,0 +1,99 @@
+package spz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+)
+
+// SplatRecordSize is the size of one splat in the .splat format
+const SplatRecordSize = 32
+
+// plyOpacityEpsilon keeps the opacity logit finite for fully opaque or
+// transparent splats
+const plyOpacityEpsilon = 1.0 / 510
+
+// colorToSHDC converts a decoded display color to the SH DC coefficient
+func colorToSHDC(c uint8) float32 {
+	return float32((float64(c)/255 - 0.5) / SH_C0)
+}
+
+// EncodePly writes a cloud as a binary little-endian PLY file in the layout
+// produced by 3D Gaussian Splatting training: positions, zero normals, SH
+// DC and channel-major f_rest coefficients, opacity logits, log scales and
+// (w, x, y, z) rotations
+func EncodePly(w io.Writer, data *SpzData) error {
+	degree := min(data.ShDegree, 3)
+	rest := shCoeffsForDegree[degree] * 3
+
+	var header bytes.Buffer
+	header.WriteString("ply\nformat binary_little_endian 1.0\n")
+	header.WriteString("element vertex " + strconv.Itoa(len(data.Data)) + "\n")
+	names := []string{"x", "y", "z", "nx", "ny", "nz", "f_dc_0", "f_dc_1", "f_dc_2"}
+	for i := range rest {
+		names = append(names, "f_rest_"+strconv.Itoa(i))
+	}
+	names = append(names, "opacity", "scale_0", "scale_1", "scale_2", "rot_0", "rot_1", "rot_2", "rot_3")
+	for _, name := range names {
+		header.WriteString("property float " + name + "\n")
+	}
+	header.WriteString("end_header\n")
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+
+	row := make([]float32, len(names))
+	buf := make([]byte, len(names)*4)
+	coeffs := shCoeffsForDegree[degree]
+	for _, d := range data.Data {
+		row = append(row[:0], d.PositionX, d.PositionY, d.PositionZ, 0, 0, 0,
+			colorToSHDC(d.ColorR), colorToSHDC(d.ColorG), colorToSHDC(d.ColorB))
+
+		// DecodeSH interleaves RGB per coefficient, PLY stores all red
+		// coefficients first
+		sh := DecodeSH(d, degree)
+		for c := range 3 {
+			for k := range coeffs {
+				row = append(row, sh[k*3+c])
+			}
+		}
+
+		a := math.Min(math.Max(float64(d.ColorA)/255, plyOpacityEpsilon), 1-plyOpacityEpsilon)
+		q, _ := splatRotation(d)
+		row = append(row, float32(math.Log(a/(1-a))), d.ScaleX, d.ScaleY, d.ScaleZ,
+			float32(q[0]), float32(q[1]), float32(q[2]), float32(q[3]))
+
+		for i, v := range row {
+			binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(v))
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncodeSplat writes a cloud in the .splat format used by web viewers:
+// 32 bytes per splat holding the position and linear scale as float32,
+// RGBA bytes and the (w, x, y, z) rotation as bytes mapped from [-1, 1]
+func EncodeSplat(w io.Writer, data *SpzData) error {
+	buf := make([]byte, SplatRecordSize)
+	for _, d := range data.Data {
+		s := splatScale(d)
+		for i, v := range []float32{d.PositionX, d.PositionY, d.PositionZ, float32(s[0]), float32(s[1]), float32(s[2])} {
+			binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(v))
+		}
+		buf[24], buf[25], buf[26], buf[27] = d.ColorR, d.ColorG, d.ColorB, d.ColorA
+
+		q, _ := splatRotation(d)
+		for i := range 4 {
+			buf[28+i] = clipUint8Round(q[i]*128 + 128)
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
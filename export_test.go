@@ -0,0 +1,93 @@
+package spz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEncodePly tests the 3DGS PLY export
+func TestEncodePly(t *testing.T) {
+	data := newCompressTestData()
+	var buf bytes.Buffer
+	assert.NoError(t, EncodePly(&buf, data))
+
+	elements, err := readPly(&buf)
+	assert.NoError(t, err)
+	vertex := findPlyElement(elements, "vertex")
+	assert.NotNil(t, vertex)
+	assert.Equal(t, 2, vertex.count)
+	assert.Nil(t, vertex.property("f_rest_9"))
+
+	assert.InDelta(t, -2.5, vertex.value(1, vertex.property("y")), 1e-6)
+	assert.InDelta(t, 0.3, vertex.value(0, vertex.property("scale_2")), 1e-6)
+	// ColorR 255 is DC (1 - 0.5) / SH_C0
+	assert.InDelta(t, 0.5/SH_C0, vertex.value(0, vertex.property("f_dc_0")), 1e-3)
+	a := 1 / (1 + math.Exp(-vertex.value(0, vertex.property("opacity"))))
+	assert.InDelta(t, 200.0/255, a, 1e-6)
+	// f_rest is channel-major: f_rest_3 is the first green coefficient
+	assert.InDelta(t, decodeSHByte(data.Data[0].SH1[1]), vertex.value(0, vertex.property("f_rest_3")), 1e-6)
+	q, _ := splatRotation(data.Data[1])
+	assert.InDelta(t, q[0], vertex.value(1, vertex.property("rot_0")), 1e-6)
+}
+
+// TestEncodeSplat tests the .splat export
+func TestEncodeSplat(t *testing.T) {
+	data := newCompressTestData()
+	var buf bytes.Buffer
+	assert.NoError(t, EncodeSplat(&buf, data))
+	bts := buf.Bytes()
+	assert.Len(t, bts, 2*SplatRecordSize)
+
+	rec := bts[SplatRecordSize:]
+	assert.Equal(t, float32(-1.5), math.Float32frombits(binary.LittleEndian.Uint32(rec)))
+	assert.InDelta(t, math.Exp(0.4), math.Float32frombits(binary.LittleEndian.Uint32(rec[12:])), 1e-6)
+	assert.Equal(t, []byte{64, 128, 255, 180}, rec[24:28])
+}
+
+// TestEncodeGlb tests the glTF export
+func TestEncodeGlb(t *testing.T) {
+	data := newCompressTestData()
+	var buf bytes.Buffer
+	assert.NoError(t, EncodeGlb(&buf, data))
+	bts := buf.Bytes()
+
+	assert.Equal(t, []byte("glTF"), bts[:4])
+	assert.Equal(t, uint32(len(bts)), binary.LittleEndian.Uint32(bts[8:]))
+	jsonLen := binary.LittleEndian.Uint32(bts[12:])
+	assert.Zero(t, jsonLen%4)
+
+	var doc struct {
+		Meshes []struct {
+			Primitives []struct {
+				Attributes map[string]int `json:"attributes"`
+				Mode       int            `json:"mode"`
+			} `json:"primitives"`
+		} `json:"meshes"`
+		Accessors []gltfAccessor `json:"accessors"`
+	}
+	assert.NoError(t, json.Unmarshal(bts[20:20+jsonLen], &doc))
+	attrs := doc.Meshes[0].Primitives[0].Attributes
+	assert.Len(t, attrs, 4+3)
+	assert.Contains(t, attrs, "KHR_gaussian_splatting:SH_DEGREE_1_COEF_2")
+	assert.Equal(t, []float32{-1.5, -2.5, -3.5}, doc.Accessors[attrs["POSITION"]].Min)
+	assert.Equal(t, 2, doc.Accessors[attrs["COLOR_0"]].Count)
+
+	binLen := binary.LittleEndian.Uint32(bts[20+jsonLen:])
+	assert.Equal(t, len(bts), int(20+jsonLen+8+binLen))
+
+	// An empty cloud has no accessors and no binary chunk
+	buf.Reset()
+	data.Data, data.NumPoints = nil, 0
+	assert.NoError(t, EncodeGlb(&buf, data))
+	bts = buf.Bytes()
+	jsonLen = binary.LittleEndian.Uint32(bts[12:])
+	assert.Len(t, bts, int(20+jsonLen))
+	doc.Accessors = nil
+	assert.NoError(t, json.Unmarshal(bts[20:], &doc))
+	assert.Empty(t, doc.Accessors)
+}
@@ -0,0 +1,181 @@
+// Package filter removes floaters and outliers from splat clouds. Every
+// filter returns the cleaned cloud together with the indices of the removed
+// splats, so the removed set can be reviewed or written out separately. The
+// cleaned cloud shares its splats with the input.
+package filter
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"sync"
+
+	spz "github.com/flywave/go-spz"
+	"github.com/flywave/go-spz/internal/cancelcheck"
+	"github.com/flywave/go-spz/spatial"
+)
+
+// split returns a copy of data without the flagged splats, and their indices
+func split(data *spz.SpzData, remove []bool) (*spz.SpzData, []int) {
+	out := *data
+	out.Data = make([]*spz.SplatData, 0, len(data.Data))
+	var removed []int
+	for i, d := range data.Data {
+		if remove[i] {
+			removed = append(removed, i)
+		} else {
+			out.Data = append(out.Data, d)
+		}
+	}
+	out.NumPoints = uint32(len(out.Data))
+	return &out, removed
+}
+
+// parallelFor calls fn for every index in [0, n) from several goroutines. The
+// workers stop early and the context error is returned once ctx is canceled.
+func parallelFor(ctx context.Context, n int, fn func(i int)) error {
+	workers := min(runtime.GOMAXPROCS(0), max(n, 1))
+	chunk := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for w := range workers {
+		start, end := w*chunk, min(n, (w+1)*chunk)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				if cancelcheck.Check(ctx, i-start) != nil {
+					return
+				}
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// StatisticalOutliers removes splats whose mean distance to their k nearest
+// neighbours exceeds the mean over the cloud by more than stdRatio standard
+// deviations
+func StatisticalOutliers(data *spz.SpzData, k int, stdRatio float64) (*spz.SpzData, []int) {
+	out, removed, _ := StatisticalOutliersContext(context.Background(), data, k, stdRatio)
+	return out, removed
+}
+
+// StatisticalOutliersContext is StatisticalOutliers with cancellation: it
+// returns the context error once ctx is canceled
+func StatisticalOutliersContext(ctx context.Context, data *spz.SpzData, k int, stdRatio float64) (*spz.SpzData, []int, error) {
+	remove := make([]bool, len(data.Data))
+	if k <= 0 || len(data.Data) <= k {
+		out, removed := split(data, remove)
+		return out, removed, nil
+	}
+
+	positions := spatial.Positions(data)
+	tree, err := spatial.NewKDTreeContext(ctx, positions)
+	if err != nil {
+		return nil, nil, err
+	}
+	meanDist := make([]float64, len(data.Data))
+	err = parallelFor(ctx, len(data.Data), func(i int) {
+		p := [3]float32(positions[3*i : 3*i+3])
+		sum, count := 0.0, 0
+		for _, j := range tree.KNearest(p, k+1) {
+			if j == i || count == k {
+				continue
+			}
+			q := positions[3*j : 3*j+3]
+			dx, dy, dz := float64(p[0]-q[0]), float64(p[1]-q[1]), float64(p[2]-q[2])
+			sum += math.Sqrt(dx*dx + dy*dy + dz*dz)
+			count++
+		}
+		meanDist[i] = sum / float64(count)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mean, sq := 0.0, 0.0
+	for _, d := range meanDist {
+		mean += d
+		sq += d * d
+	}
+	mean /= float64(len(meanDist))
+	std := math.Sqrt(math.Max(0, sq/float64(len(meanDist))-mean*mean))
+	threshold := mean + stdRatio*std
+	for i, d := range meanDist {
+		remove[i] = d > threshold
+	}
+	out, removed := split(data, remove)
+	return out, removed, nil
+}
+
+// RadiusOutliers removes splats with fewer than minNeighbours other splats
+// within radius of their center
+func RadiusOutliers(data *spz.SpzData, radius float64, minNeighbours int) (*spz.SpzData, []int) {
+	out, removed, _ := RadiusOutliersContext(context.Background(), data, radius, minNeighbours)
+	return out, removed
+}
+
+// RadiusOutliersContext is RadiusOutliers with cancellation: it returns the
+// context error once ctx is canceled
+func RadiusOutliersContext(ctx context.Context, data *spz.SpzData, radius float64, minNeighbours int) (*spz.SpzData, []int, error) {
+	remove := make([]bool, len(data.Data))
+	positions := spatial.Positions(data)
+	tree, err := spatial.NewKDTreeContext(ctx, positions)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = parallelFor(ctx, len(data.Data), func(i int) {
+		// The query includes the splat itself
+		neighbours := len(tree.Radius([3]float32(positions[3*i:3*i+3]), float32(radius))) - 1
+		remove[i] = neighbours < minNeighbours
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	out, removed := split(data, remove)
+	return out, removed, nil
+}
+
+// Anisotropic removes needle and disc shaped splats whose largest scale
+// exceeds their smallest by more than maxRatio
+func Anisotropic(data *spz.SpzData, maxRatio float64) (*spz.SpzData, []int) {
+	remove := make([]bool, len(data.Data))
+	limit := math.Log(maxRatio)
+	for i, d := range data.Data {
+		hi := max(d.ScaleX, d.ScaleY, d.ScaleZ)
+		lo := min(d.ScaleX, d.ScaleY, d.ScaleZ)
+		remove[i] = float64(hi)-float64(lo) > limit
+	}
+	return split(data, remove)
+}
+
+// Oversized removes splats whose largest scale exceeds fraction of the
+// diagonal of the box enclosing all splat centers
+func Oversized(data *spz.SpzData, fraction float64) (*spz.SpzData, []int) {
+	remove := make([]bool, len(data.Data))
+	if len(data.Data) == 0 {
+		return split(data, remove)
+	}
+
+	lo := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	hi := [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for _, d := range data.Data {
+		p := [3]float64{float64(d.PositionX), float64(d.PositionY), float64(d.PositionZ)}
+		for c := range 3 {
+			lo[c] = math.Min(lo[c], p[c])
+			hi[c] = math.Max(hi[c], p[c])
+		}
+	}
+	diagonal := math.Sqrt((hi[0]-lo[0])*(hi[0]-lo[0]) + (hi[1]-lo[1])*(hi[1]-lo[1]) + (hi[2]-lo[2])*(hi[2]-lo[2]))
+	if diagonal == 0 {
+		return split(data, remove)
+	}
+	limit := fraction * diagonal
+
+	for i, d := range data.Data {
+		remove[i] = math.Exp(float64(max(d.ScaleX, d.ScaleY, d.ScaleZ))) > limit
+	}
+	return split(data, remove)
+}
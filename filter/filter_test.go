@@ -0,0 +1,77 @@
+package filter
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	spz "github.com/flywave/go-spz"
+	"github.com/stretchr/testify/assert"
+)
+
+// newFilterTestData returns a 5x5x5 grid of small splats with spacing 0.1,
+// followed by one isolated splat far away
+func newFilterTestData() *spz.SpzData {
+	s := float32(math.Log(0.01))
+	data := &spz.SpzData{Magic: spz.SPZ_MAGIC, Version: 3, FractionalBits: 12}
+	for x := range 5 {
+		for y := range 5 {
+			for z := range 5 {
+				data.Data = append(data.Data, &spz.SplatData{
+					PositionX: float32(x) / 10, PositionY: float32(y) / 10, PositionZ: float32(z) / 10,
+					ScaleX: s, ScaleY: s, ScaleZ: s,
+					RotationW: 255, RotationX: 128, RotationY: 128, RotationZ: 128,
+					ColorA: 255,
+				})
+			}
+		}
+	}
+	data.Data = append(data.Data, &spz.SplatData{PositionX: 5, PositionY: 5, PositionZ: 5, ScaleX: s, ScaleY: s, ScaleZ: s})
+	data.NumPoints = uint32(len(data.Data))
+	return data
+}
+
+// TestOutliers tests statistical and radius outlier removal
+func TestOutliers(t *testing.T) {
+	data := newFilterTestData()
+
+	cleaned, removed := StatisticalOutliers(data, 6, 2)
+	assert.Equal(t, []int{125}, removed)
+	assert.Equal(t, uint32(125), cleaned.NumPoints)
+	assert.Len(t, cleaned.Data, 125)
+	assert.Same(t, data.Data[0], cleaned.Data[0])
+	assert.Equal(t, uint32(126), data.NumPoints)
+
+	_, removed = RadiusOutliers(data, 0.15, 2)
+	assert.Equal(t, []int{125}, removed)
+	// Grid corners have 3 neighbours at the grid spacing
+	_, removed = RadiusOutliers(data, 0.11, 4)
+	assert.Len(t, removed, 8+1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := StatisticalOutliersContext(ctx, data, 6, 2)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, _, err = RadiusOutliersContext(ctx, data, 0.15, 2)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// TestShapeFilters tests anisotropy and oversize removal
+func TestShapeFilters(t *testing.T) {
+	data := newFilterTestData()
+	data.Data[3].ScaleX = float32(math.Log(1))
+	data.Data[7].ScaleY = float32(math.Log(0.2))
+
+	_, removed := Anisotropic(data, 50)
+	assert.Equal(t, []int{3}, removed)
+	_, removed = Anisotropic(data, 10)
+	assert.Equal(t, []int{3, 7}, removed)
+
+	// The scene diagonal is about 7.8
+	cleaned, removed := Oversized(data, 0.1)
+	assert.Equal(t, []int{3}, removed)
+	assert.Len(t, cleaned.Data, 125)
+
+	_, removed = Oversized(&spz.SpzData{Data: data.Data[:1]}, 0.1)
+	assert.Empty(t, removed)
+}
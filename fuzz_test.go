@@ -0,0 +1,148 @@
+package spz
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// malformedCorpus returns the checked-in malformed files by name
+func malformedCorpus(t testing.TB) map[string][]byte {
+	paths, err := filepath.Glob(filepath.Join("testdata", "malformed", "*.spz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte, len(paths))
+	for _, p := range paths {
+		bts, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[filepath.Base(p)] = bts
+	}
+	return files
+}
+
+// addFuzzSeeds seeds a fuzz target with valid streams of every SH degree and
+// version, the chunked container and the malformed corpus
+func addFuzzSeeds(f *testing.F, decompress bool) {
+	for _, version := range []uint32{2, 3} {
+		for degree := range uint8(4) {
+			data := newChunkedTestData(3)
+			data.Version, data.ShDegree = version, degree
+			bts := mustEncode(f, data, nil)
+			if decompress {
+				bts, _ = decompressAuto(bts)
+			}
+			f.Add(bts)
+		}
+	}
+	for _, bts := range malformedCorpus(f) {
+		if decompress {
+			bts, _ = decompressAuto(bts)
+		}
+		f.Add(bts)
+	}
+}
+
+// TestMalformedCorpus tests that every malformed file is rejected
+func TestMalformedCorpus(t *testing.T) {
+	files := malformedCorpus(t)
+	assert.NotEmpty(t, files)
+	for name, bts := range files {
+		_, err := DecodeWithOptions(bts, &DecodeOptions{SHCodebook: true, Metadata: true})
+		assert.Error(t, err, name)
+	}
+}
+
+// TestEncodeMalformedSplats tests that the writer rejects inconsistent data,
+// counts the splats itself and pads missing or short SH bands instead of
+// panicking
+func TestEncodeMalformedSplats(t *testing.T) {
+	data := newChunkedTestData(2)
+	data.ShDegree = 3
+	data.Data[0].SH1 = nil
+	data.Data[0].SH3 = make([]byte, 21)
+	for i := range data.Data[0].SH3 {
+		data.Data[0].SH3[i] = 192
+	}
+	data.Data[1].SH1 = []byte{200, 64}
+	decoded, err := Decode(mustEncode(t, data, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(128), decoded.Data[0].SH2[0])
+	assert.Equal(t, uint8(192), decoded.Data[0].SH3[0])
+	assert.Equal(t, []byte{200, 64, 128}, decoded.Data[1].SH2[:3])
+
+	data.NumPoints = 3
+	decoded, err = Decode(mustEncode(t, data, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), decoded.NumPoints)
+	data.NumPoints = 2
+	data.ShDegree = 4
+	_, err = Encode(data)
+	assert.EqualError(t, err, "Unsupported SH degree: 4")
+	data.ShDegree = 0
+	data.Data[1] = nil
+	_, err = Encode(data)
+	assert.Error(t, err)
+
+	header := (&SpzData{Magic: SPZ_MAGIC, Version: 4, FractionalBits: 12}).ToBytes()
+	_, err = ParseSpzHeader(header)
+	assert.EqualError(t, err, "Unsupported SPZ version: 4")
+}
+
+// FuzzParseSpzHeader tests that header parsing never panics and that
+// accepted headers serialize back to the same bytes
+func FuzzParseSpzHeader(f *testing.F) {
+	addFuzzSeeds(f, true)
+	f.Fuzz(func(t *testing.T, bts []byte) {
+		h, err := ParseSpzHeader(bts)
+		if err != nil {
+			return
+		}
+		assert.Equal(t, bts[:HeaderSizeSpz], h.ToBytes())
+	})
+}
+
+// FuzzReadPayload tests that reading an uncompressed payload never panics
+// and that accepted payloads decode to NumPoints splats
+func FuzzReadPayload(f *testing.F) {
+	addFuzzSeeds(f, true)
+	f.Fuzz(func(t *testing.T, bts []byte) {
+		h, datas, ext, err := splitSpzPayload(bts)
+		if err != nil {
+			return
+		}
+		if ext != nil {
+			_, _ = decodeMetadata(ext)
+		}
+		splats, err := readSpzDatas(context.Background(), datas, h, nil)
+		if err != nil {
+			return
+		}
+		assert.Len(t, splats, int(h.NumPoints))
+		if len(splats) > 0 {
+			_, err = DecodeRange(datas, h, len(splats)-1, len(splats), &DecodeOptions{SHCodebook: true})
+			assert.NoError(t, err)
+		}
+	})
+}
+
+// FuzzDecode tests that decoding a file never panics and that accepted files
+// can be encoded again
+func FuzzDecode(f *testing.F) {
+	addFuzzSeeds(f, false)
+	f.Fuzz(func(t *testing.T, bts []byte) {
+		data, err := DecodeWithOptions(bts, &DecodeOptions{SHCodebook: true, Metadata: true})
+		if err != nil {
+			return
+		}
+		assert.Len(t, data.Data, int(data.NumPoints))
+		data.Flags &^= FlagSHCodebook
+		_, err = Encode(data)
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,86 @@
+package spz
+
+import "math"
+
+// degenerateQuatEpsilon is the quaternion length below which a rotation
+// cannot be normalized
+const degenerateQuatEpsilon = 1e-6
+
+// splatRotation returns the normalized (w, x, y, z) rotation of a splat. The
+// second result is false if the stored quaternion is degenerate, in which
+// case the identity rotation is returned.
+func splatRotation(d *SplatData) ([4]float64, bool) {
+	q := [4]float64{
+		float64(d.RotationW)/128.0 - 1.0,
+		float64(d.RotationX)/128.0 - 1.0,
+		float64(d.RotationY)/128.0 - 1.0,
+		float64(d.RotationZ)/128.0 - 1.0,
+	}
+	qlen := math.Sqrt(q[0]*q[0] + q[1]*q[1] + q[2]*q[2] + q[3]*q[3])
+	if qlen < degenerateQuatEpsilon || math.IsNaN(qlen) {
+		return [4]float64{1, 0, 0, 0}, false
+	}
+	return [4]float64{q[0] / qlen, q[1] / qlen, q[2] / qlen, q[3] / qlen}, true
+}
+
+// setSplatRotation stores a (w, x, y, z) quaternion on a splat, normalized
+// and with a non-negative w
+func setSplatRotation(d *SplatData, q [4]float64) {
+	qlen := math.Sqrt(q[0]*q[0] + q[1]*q[1] + q[2]*q[2] + q[3]*q[3])
+	if qlen < degenerateQuatEpsilon || math.IsNaN(qlen) {
+		q, qlen = [4]float64{1, 0, 0, 0}, 1
+	}
+	if q[0] < 0 {
+		qlen = -qlen
+	}
+	d.RotationW = clipUint8Round(q[0]/qlen*128.0 + 128.0)
+	d.RotationX = clipUint8Round(q[1]/qlen*128.0 + 128.0)
+	d.RotationY = clipUint8Round(q[2]/qlen*128.0 + 128.0)
+	d.RotationZ = clipUint8Round(q[3]/qlen*128.0 + 128.0)
+}
+
+// rotationAngleDegrees returns the angle between the rotations of two splats
+func rotationAngleDegrees(a, b *SplatData) float64 {
+	qa, _ := splatRotation(a)
+	qb, _ := splatRotation(b)
+	dot := math.Abs(qa[0]*qb[0] + qa[1]*qb[1] + qa[2]*qb[2] + qa[3]*qb[3])
+	return 2 * math.Acos(math.Min(1, dot)) * 180 / math.Pi
+}
+
+// splatScale returns the linear scale of a splat (the stored scales are logarithmic)
+func splatScale(d *SplatData) [3]float64 {
+	return [3]float64{
+		math.Exp(float64(d.ScaleX)),
+		math.Exp(float64(d.ScaleY)),
+		math.Exp(float64(d.ScaleZ)),
+	}
+}
+
+// quatToMatrix converts a normalized (w, x, y, z) quaternion to a rotation matrix
+func quatToMatrix(q [4]float64) [3][3]float64 {
+	w, x, y, z := q[0], q[1], q[2], q[3]
+	return [3][3]float64{
+		{1 - 2*(y*y+z*z), 2 * (x*y - w*z), 2 * (x*z + w*y)},
+		{2 * (x*y + w*z), 1 - 2*(x*x+z*z), 2 * (y*z - w*x)},
+		{2 * (x*z - w*y), 2 * (y*z + w*x), 1 - 2*(x*x+y*y)},
+	}
+}
+
+// splatExtent returns the half-size of the axis-aligned box enclosing the
+// k-sigma ellipsoid of a splat
+func splatExtent(d *SplatData, k float64) [3]float64 {
+	q, _ := splatRotation(d)
+	r := quatToMatrix(q)
+	s := splatScale(d)
+
+	var ext [3]float64
+	for i := range 3 {
+		sum := 0.0
+		for j := range 3 {
+			v := r[i][j] * s[j]
+			sum += v * v
+		}
+		ext[i] = k * math.Sqrt(sum)
+	}
+	return ext
+}
@@ -0,0 +1,178 @@
+package spz
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+)
+
+const (
+	// WGS84 ellipsoid semi-major axis and flattening
+	wgs84A = 6378137.0
+	wgs84F = 1 / 298.257223563
+
+	// GeoSidecarSuffix is appended to an SPZ file name to name its
+	// georeference sidecar
+	GeoSidecarSuffix = ".geo.json"
+)
+
+var wgs84E2 = wgs84F * (2 - wgs84F)
+
+// GeoReference places a cloud on the WGS84 ellipsoid. Splat positions are
+// local east, north, up (ENU) coordinates in meters relative to the origin,
+// which keeps them small enough for the fixed-point position encoding. A
+// georeference is kept in a sidecar file or as JSON metadata under
+// MetaKeyGeoReference.
+type GeoReference struct {
+	// Latitude and Longitude of the origin in degrees
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// Height of the origin above the ellipsoid in meters
+	Height float64 `json:"height"`
+}
+
+// NewGeoReferenceFromECEF returns a georeference with its origin at an
+// earth-centered, earth-fixed position in meters
+func NewGeoReferenceFromECEF(ecef [3]float64) *GeoReference {
+	lat, lon, h := ECEFToWGS84(ecef)
+	return &GeoReference{Latitude: lat, Longitude: lon, Height: h}
+}
+
+// WGS84ToECEF converts a geodetic position in degrees and meters to ECEF
+func WGS84ToECEF(lat, lon, height float64) [3]float64 {
+	phi, lambda := lat*math.Pi/180, lon*math.Pi/180
+	sinPhi, cosPhi := math.Sincos(phi)
+	sinLambda, cosLambda := math.Sincos(lambda)
+	n := wgs84A / math.Sqrt(1-wgs84E2*sinPhi*sinPhi)
+	return [3]float64{
+		(n + height) * cosPhi * cosLambda,
+		(n + height) * cosPhi * sinLambda,
+		(n*(1-wgs84E2) + height) * sinPhi,
+	}
+}
+
+// ECEFToWGS84 converts an ECEF position in meters to latitude and longitude
+// in degrees and height above the ellipsoid in meters
+func ECEFToWGS84(ecef [3]float64) (lat, lon, height float64) {
+	x, y, z := ecef[0], ecef[1], ecef[2]
+	p := math.Hypot(x, y)
+	lambda := math.Atan2(y, x)
+	if p < 1e-9 {
+		// On the polar axis
+		b := wgs84A * (1 - wgs84F)
+		return math.Copysign(90, z), 0, math.Abs(z) - b
+	}
+
+	phi := math.Atan2(z, p*(1-wgs84E2))
+	for range 8 {
+		sinPhi := math.Sin(phi)
+		n := wgs84A / math.Sqrt(1-wgs84E2*sinPhi*sinPhi)
+		height = p/math.Cos(phi) - n
+		next := math.Atan2(z, p*(1-wgs84E2*n/(n+height)))
+		if math.Abs(next-phi) < 1e-14 {
+			phi = next
+			break
+		}
+		phi = next
+	}
+	sinPhi := math.Sin(phi)
+	n := wgs84A / math.Sqrt(1-wgs84E2*sinPhi*sinPhi)
+	height = p/math.Cos(phi) - n
+	return phi * 180 / math.Pi, lambda * 180 / math.Pi, height
+}
+
+// Origin returns the origin in ECEF meters
+func (g *GeoReference) Origin() [3]float64 {
+	return WGS84ToECEF(g.Latitude, g.Longitude, g.Height)
+}
+
+// ENUFrame returns the east, north and up axes at the origin as the columns
+// of a rotation from local ENU to ECEF directions
+func (g *GeoReference) ENUFrame() [3][3]float64 {
+	sinPhi, cosPhi := math.Sincos(g.Latitude * math.Pi / 180)
+	sinLambda, cosLambda := math.Sincos(g.Longitude * math.Pi / 180)
+	return [3][3]float64{
+		{-sinLambda, -sinPhi * cosLambda, cosPhi * cosLambda},
+		{cosLambda, -sinPhi * sinLambda, cosPhi * sinLambda},
+		{0, cosPhi, sinPhi},
+	}
+}
+
+// ENUToECEF converts a local ENU position to ECEF
+func (g *GeoReference) ENUToECEF(enu [3]float64) [3]float64 {
+	origin, r := g.Origin(), g.ENUFrame()
+	var ecef [3]float64
+	for i := range 3 {
+		ecef[i] = origin[i] + r[i][0]*enu[0] + r[i][1]*enu[1] + r[i][2]*enu[2]
+	}
+	return ecef
+}
+
+// ECEFToENU converts an ECEF position to local ENU
+func (g *GeoReference) ECEFToENU(ecef [3]float64) [3]float64 {
+	origin, r := g.Origin(), g.ENUFrame()
+	d := [3]float64{ecef[0] - origin[0], ecef[1] - origin[1], ecef[2] - origin[2]}
+	var enu [3]float64
+	for i := range 3 {
+		enu[i] = r[0][i]*d[0] + r[1][i]*d[1] + r[2][i]*d[2]
+	}
+	return enu
+}
+
+// ECEFPositions returns the splat centers in ECEF meters as a columnar
+// x, y, z buffer. Float64 is needed to keep precision at earth scale.
+func (g *GeoReference) ECEFPositions(data *SpzData) []float64 {
+	origin, r := g.Origin(), g.ENUFrame()
+	out := make([]float64, 0, len(data.Data)*3)
+	for _, d := range data.Data {
+		enu := [3]float64{float64(d.PositionX), float64(d.PositionY), float64(d.PositionZ)}
+		for i := range 3 {
+			out = append(out, origin[i]+r[i][0]*enu[0]+r[i][1]*enu[1]+r[i][2]*enu[2])
+		}
+	}
+	return out
+}
+
+// SetECEFPositions stores ECEF splat centers, given as a columnar x, y, z
+// buffer, as ENU positions relative to the origin. It fails without
+// modifying the data if a position is too far from the origin to encode.
+func (g *GeoReference) SetECEFPositions(data *SpzData, ecef []float64) error {
+	if len(ecef) != len(data.Data)*3 {
+		return &SpzError{"Invalid positions: length must be 3 per splat"}
+	}
+	enu := make([][3]float64, len(data.Data))
+	for i := range data.Data {
+		enu[i] = g.ECEFToENU([3]float64(ecef[3*i : 3*i+3]))
+		for c := range 3 {
+			if positionOverflows(enu[i][c]) {
+				return &SpzError{"Invalid positions: too far from the georeference origin"}
+			}
+		}
+	}
+	for i, d := range data.Data {
+		d.PositionX, d.PositionY, d.PositionZ = float32(enu[i][0]), float32(enu[i][1]), float32(enu[i][2])
+	}
+	return nil
+}
+
+// ReadGeoReference reads the georeference sidecar of an SPZ file
+func ReadGeoReference(spzFile string) (*GeoReference, error) {
+	bts, err := os.ReadFile(spzFile + GeoSidecarSuffix)
+	if err != nil {
+		return nil, err
+	}
+	g := &GeoReference{}
+	if err := json.Unmarshal(bts, g); err != nil {
+		return nil, &SpzError{"Invalid georeference: " + err.Error()}
+	}
+	return g, nil
+}
+
+// WriteGeoReference writes the georeference sidecar of an SPZ file
+func WriteGeoReference(spzFile string, g *GeoReference) error {
+	bts, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(spzFile+GeoSidecarSuffix, bts, 0o644)
+}
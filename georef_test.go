@@ -0,0 +1,53 @@
+package spz
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGeoReference tests WGS84, ECEF and ENU conversions
+func TestGeoReference(t *testing.T) {
+	assert.InDeltaSlice(t, []float64{6378137, 0, 0}, toSlice(WGS84ToECEF(0, 0, 0)), 1e-6)
+	assert.InDelta(t, 6356752.314245, WGS84ToECEF(90, 0, 0)[2], 1e-6)
+
+	lat, lon, h := ECEFToWGS84(WGS84ToECEF(39.9042, 116.4074, 43.5))
+	assert.InDelta(t, 39.9042, lat, 1e-10)
+	assert.InDelta(t, 116.4074, lon, 1e-10)
+	assert.InDelta(t, 43.5, h, 1e-6)
+	lat, _, h = ECEFToWGS84(WGS84ToECEF(-90, 0, 10))
+	assert.Equal(t, -90.0, lat)
+	assert.InDelta(t, 10, h, 1e-6)
+
+	// At lat 0, lon 0 east is +Y, north is +Z and up is +X in ECEF
+	g := NewGeoReferenceFromECEF([3]float64{6378137, 0, 0})
+	assert.InDelta(t, 0, g.Latitude, 1e-12)
+	assert.InDeltaSlice(t, []float64{6378137 + 3, 1, 2}, toSlice(g.ENUToECEF([3]float64{1, 2, 3})), 1e-6)
+
+	g = &GeoReference{Latitude: 31.2304, Longitude: 121.4737, Height: 12}
+	enu := [3]float64{120.5, -33.25, 7}
+	assert.InDeltaSlice(t, toSlice(enu), toSlice(g.ECEFToENU(g.ENUToECEF(enu))), 1e-6)
+
+	data := newCompressTestData()
+	ecef := g.ECEFPositions(data)
+	assert.Len(t, ecef, 6)
+	data.Data[0].PositionX = 0
+	assert.NoError(t, g.SetECEFPositions(data, ecef))
+	assert.InDelta(t, 1.5, data.Data[0].PositionX, 1e-6)
+
+	far := append([]float64(nil), ecef...)
+	far[0] += 5000
+	assert.Error(t, g.SetECEFPositions(data, far))
+	assert.Error(t, g.SetECEFPositions(data, ecef[:3]))
+
+	file := filepath.Join(t.TempDir(), "scene.spz")
+	assert.NoError(t, WriteGeoReference(file, g))
+	read, err := ReadGeoReference(file)
+	assert.NoError(t, err)
+	assert.Equal(t, g, read)
+}
+
+func toSlice(v [3]float64) []float64 {
+	return v[:]
+}
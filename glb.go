@@ -0,0 +1,184 @@
+package spz
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+)
+
+const (
+	glbMagic       = 0x46546c67 // "glTF"
+	glbChunkJSON   = 0x4e4f534a // "JSON"
+	glbChunkBinary = 0x004e4942 // "BIN\0"
+
+	gltfFloat        = 5126
+	gltfUnsignedByte = 5121
+	gltfArrayBuffer  = 34962
+	gltfModePoints   = 0
+
+	// gaussianSplattingExtension names the glTF extension carrying splats
+	gaussianSplattingExtension = "KHR_gaussian_splatting"
+)
+
+type gltfAccessor struct {
+	BufferView    int       `json:"bufferView"`
+	ComponentType int       `json:"componentType"`
+	Normalized    bool      `json:"normalized,omitempty"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float32 `json:"min,omitempty"`
+	Max           []float32 `json:"max,omitempty"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	Target     int `json:"target"`
+}
+
+// glbBuilder collects vertex attributes into a single binary buffer
+type glbBuilder struct {
+	bin         []byte
+	accessors   []gltfAccessor
+	bufferViews []gltfBufferView
+	attributes  map[string]int
+}
+
+func (b *glbBuilder) add(name, typ string, componentType int, normalized bool, count int, data []byte) *gltfAccessor {
+	b.bufferViews = append(b.bufferViews, gltfBufferView{ByteOffset: len(b.bin), ByteLength: len(data), Target: gltfArrayBuffer})
+	b.bin = append(b.bin, data...)
+	for len(b.bin)%4 != 0 {
+		b.bin = append(b.bin, 0)
+	}
+	b.accessors = append(b.accessors, gltfAccessor{
+		BufferView:    len(b.bufferViews) - 1,
+		ComponentType: componentType,
+		Normalized:    normalized,
+		Count:         count,
+		Type:          typ,
+	})
+	b.attributes[name] = len(b.accessors) - 1
+	return &b.accessors[len(b.accessors)-1]
+}
+
+func (b *glbBuilder) addFloats(name, typ string, count int, values []float32) *gltfAccessor {
+	data := make([]byte, len(values)*4)
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(v))
+	}
+	return b.add(name, typ, gltfFloat, false, count, data)
+}
+
+// EncodeGlb writes a cloud as a binary glTF file with a single point
+// primitive following the draft KHR_gaussian_splatting extension: splat
+// centers in POSITION, display color and opacity in COLOR_0, (x, y, z, w)
+// rotations, linear scales and the higher SH coefficients in extension
+// attributes. Viewers without the extension show a point cloud. An empty
+// cloud is written as an empty scene, since glTF forbids empty accessors.
+func EncodeGlb(w io.Writer, data *SpzData) error {
+	n := len(data.Data)
+	if n == 0 {
+		return writeGlb(w, map[string]any{
+			"asset":  map[string]any{"version": "2.0", "generator": "go-spz"},
+			"scene":  0,
+			"scenes": []any{map[string]any{}},
+		}, nil)
+	}
+	degree := min(data.ShDegree, 3)
+	b := &glbBuilder{attributes: map[string]int{}}
+
+	positions := make([]float32, 0, n*3)
+	colors := make([]byte, 0, n*4)
+	rotations := make([]float32, 0, n*4)
+	scales := make([]float32, 0, n*3)
+	lo := []float32{float32(math.Inf(1)), float32(math.Inf(1)), float32(math.Inf(1))}
+	hi := []float32{float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1))}
+	for _, d := range data.Data {
+		p := []float32{d.PositionX, d.PositionY, d.PositionZ}
+		for c := range 3 {
+			lo[c], hi[c] = min(lo[c], p[c]), max(hi[c], p[c])
+		}
+		positions = append(positions, p...)
+		colors = append(colors, d.ColorR, d.ColorG, d.ColorB, d.ColorA)
+		q, _ := splatRotation(d)
+		rotations = append(rotations, float32(q[1]), float32(q[2]), float32(q[3]), float32(q[0]))
+		s := splatScale(d)
+		scales = append(scales, float32(s[0]), float32(s[1]), float32(s[2]))
+	}
+
+	pos := b.addFloats("POSITION", "VEC3", n, positions)
+	pos.Min, pos.Max = lo, hi
+	b.add("COLOR_0", "VEC4", gltfUnsignedByte, true, n, colors)
+	b.addFloats(gaussianSplattingExtension+":ROTATION", "VEC4", n, rotations)
+	b.addFloats(gaussianSplattingExtension+":SCALE", "VEC3", n, scales)
+
+	coeffs := shCoeffsForDegree[degree]
+	if coeffs > 0 {
+		sh := make([][]float32, coeffs)
+		for _, d := range data.Data {
+			values := DecodeSH(d, degree)
+			for k := range coeffs {
+				sh[k] = append(sh[k], values[k*3:k*3+3]...)
+			}
+		}
+		for k := range coeffs {
+			band := 1
+			for shBandStart[band+1] <= k {
+				band++
+			}
+			name := gaussianSplattingExtension + ":SH_DEGREE_" + strconv.Itoa(band) + "_COEF_" + strconv.Itoa(k-shBandStart[band])
+			b.addFloats(name, "VEC3", n, sh[k])
+		}
+	}
+
+	doc := map[string]any{
+		"asset":          map[string]any{"version": "2.0", "generator": "go-spz"},
+		"extensionsUsed": []string{gaussianSplattingExtension},
+		"scene":          0,
+		"scenes":         []any{map[string]any{"nodes": []int{0}}},
+		"nodes":          []any{map[string]any{"mesh": 0}},
+		"meshes": []any{map[string]any{"primitives": []any{map[string]any{
+			"attributes": b.attributes,
+			"mode":       gltfModePoints,
+			"extensions": map[string]any{gaussianSplattingExtension: map[string]any{}},
+		}}}},
+		"accessors":   b.accessors,
+		"bufferViews": b.bufferViews,
+		"buffers":     []any{map[string]any{"byteLength": len(b.bin)}},
+	}
+	return writeGlb(w, doc, b.bin)
+}
+
+// writeGlb writes the GLB container of a glTF document; the binary chunk
+// is omitted when bin is empty
+func writeGlb(w io.Writer, doc map[string]any, bin []byte) error {
+	js, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	for len(js)%4 != 0 {
+		js = append(js, ' ')
+	}
+
+	size := 12 + 8 + len(js)
+	if len(bin) > 0 {
+		size += 8 + len(bin)
+	}
+	out := make([]byte, 0, size)
+	out = binary.LittleEndian.AppendUint32(out, glbMagic)
+	out = binary.LittleEndian.AppendUint32(out, 2)
+	out = binary.LittleEndian.AppendUint32(out, uint32(size))
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(js)))
+	out = binary.LittleEndian.AppendUint32(out, glbChunkJSON)
+	out = append(out, js...)
+	if len(bin) > 0 {
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(bin)))
+		out = binary.LittleEndian.AppendUint32(out, glbChunkBinary)
+		out = append(out, bin...)
+	}
+	_, err = w.Write(out)
+	return err
+}
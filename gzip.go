@@ -6,9 +6,12 @@ import (
 	"io"
 )
 
-func compressGzip(bts []byte) ([]byte, error) {
+func compressGzip(bts []byte, level int) ([]byte, error) {
 	var buf bytes.Buffer
-	gz := gzip.NewWriter(&buf)
+	gz, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
 
 	if _, err := gz.Write(bts); err != nil {
 		return nil, err
@@ -0,0 +1,131 @@
+package spz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strconv"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDecodeCompressedPly tests decoding of a single-chunk compressed PLY
+func TestDecodeCompressedPly(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("ply\nformat binary_little_endian 1.0\ncomment generated by test\nelement chunk 1\n")
+	for _, name := range []string{
+		"min_x", "min_y", "min_z", "max_x", "max_y", "max_z",
+		"min_scale_x", "min_scale_y", "min_scale_z", "max_scale_x", "max_scale_y", "max_scale_z",
+	} {
+		buf.WriteString("property float " + name + "\n")
+	}
+	buf.WriteString("element vertex 2\nproperty uint packed_position\nproperty uint packed_rotation\nproperty uint packed_scale\nproperty uint packed_color\n")
+	buf.WriteString("element sh 2\n")
+	for i := range 9 {
+		buf.WriteString("property uchar f_rest_" + strconv.Itoa(i) + "\n")
+	}
+	buf.WriteString("end_header\n")
+
+	chunk := []float32{-1, -2, -3, 1, 2, 3, -4, -4, -4, 0, 0, 0}
+	assert.NoError(t, binary.Write(&buf, binary.LittleEndian, chunk))
+
+	// Splat 0: minimum corner, identity rotation (w omitted as largest)
+	// Splat 1: maximum corner, max scale
+	identity := uint32(0)<<30 | 512<<20 | 512<<10 | 512
+	vertices := []uint32{
+		0, identity, 0, 0xff000080,
+		0xffffffff, identity, 0xffffffff, 0x00ff00ff,
+	}
+	assert.NoError(t, binary.Write(&buf, binary.LittleEndian, vertices))
+	// Channel-major SH: red coefficients, then green, then blue
+	assert.NoError(t, binary.Write(&buf, binary.LittleEndian, []uint8{
+		192, 128, 128, 128, 128, 128, 128, 128, 128,
+		128, 128, 128, 128, 128, 128, 64, 128, 128,
+	}))
+
+	data, err := DecodeCompressedPly(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), data.NumPoints)
+	assert.Equal(t, uint8(1), data.ShDegree)
+
+	a, b := data.Data[0], data.Data[1]
+	assert.Equal(t, [3]float32{-1, -2, -3}, [3]float32{a.PositionX, a.PositionY, a.PositionZ})
+	assert.Equal(t, [3]float32{1, 2, 3}, [3]float32{b.PositionX, b.PositionY, b.PositionZ})
+	assert.Equal(t, float32(-4), a.ScaleX)
+	assert.Equal(t, float32(0), b.ScaleZ)
+	assert.Equal(t, uint8(255), a.ColorR)
+	assert.Equal(t, uint8(0x80), a.ColorA)
+	assert.Equal(t, uint8(255), b.ColorG)
+	assert.Equal(t, uint8(255), a.RotationW)
+	assert.InDelta(t, 128, int(a.RotationX), 1)
+
+	// f_rest_0 is the first red coefficient, f_rest_6 the first blue one
+	assert.Equal(t, encodeSplatSH(decodeCompressedPlySH(192)), a.SH1[0])
+	assert.Equal(t, encodeSplatSH(decodeCompressedPlySH(128)), a.SH1[1])
+	assert.Equal(t, encodeSplatSH(decodeCompressedPlySH(64)), b.SH1[2])
+}
+
+func encodeTestPng(t *testing.T, w, h int, pixels [][4]uint8) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i, p := range pixels {
+		img.SetNRGBA(i%w, i/w, color.NRGBA{p[0], p[1], p[2], p[3]})
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// TestDecodeSog tests decoding of a version 2 SOG scene with PNG textures
+func TestDecodeSog(t *testing.T) {
+	codebook := make([]float64, 256)
+	for i := range codebook {
+		codebook[i] = float64(i)/128 - 1
+	}
+	meta := map[string]any{
+		"version": 2,
+		"count":   2,
+		"means":   map[string]any{"mins": []float64{-1, -1, -1}, "maxs": []float64{1, 1, 1}, "files": []string{"means_l.png", "means_u.png"}},
+		"scales":  map[string]any{"codebook": codebook, "files": []string{"scales.png"}},
+		"quats":   map[string]any{"files": []string{"quats.png"}},
+		"sh0":     map[string]any{"codebook": codebook, "files": []string{"sh0.png"}},
+		"shN":     map[string]any{"count": 1, "bands": 1, "codebook": codebook, "files": []string{"shN_centroids.png", "shN_labels.png"}},
+	}
+	metaBytes, err := json.Marshal(meta)
+	assert.NoError(t, err)
+
+	fsys := fstest.MapFS{
+		"scene/meta.json": {Data: metaBytes},
+		// Splat 0 at the log-space minimum, splat 1 at the middle (origin)
+		"scene/means_l.png":       {Data: encodeTestPng(t, 2, 1, [][4]uint8{{0, 0, 0, 255}, {0xff, 0xff, 0xff, 255}})},
+		"scene/means_u.png":       {Data: encodeTestPng(t, 2, 1, [][4]uint8{{0, 0, 0, 255}, {0x7f, 0x7f, 0x7f, 255}})},
+		"scene/scales.png":        {Data: encodeTestPng(t, 2, 1, [][4]uint8{{0, 128, 255, 255}, {128, 128, 128, 255}})},
+		"scene/quats.png":         {Data: encodeTestPng(t, 2, 1, [][4]uint8{{128, 128, 128, 252}, {128, 128, 128, 255}})},
+		"scene/sh0.png":           {Data: encodeTestPng(t, 2, 1, [][4]uint8{{128, 128, 128, 200}, {255, 0, 128, 10}})},
+		"scene/shN_centroids.png": {Data: encodeTestPng(t, 192, 1, [][4]uint8{{192, 128, 128, 255}, {128, 128, 128, 255}, {128, 128, 64, 255}})},
+		"scene/shN_labels.png":    {Data: encodeTestPng(t, 2, 1, [][4]uint8{{0, 0, 0, 255}, {0, 0, 0, 255}})},
+	}
+
+	data, err := DecodeSog(fsys, "scene/meta.json")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), data.NumPoints)
+	assert.Equal(t, uint8(1), data.ShDegree)
+
+	a, b := data.Data[0], data.Data[1]
+	assert.InDelta(t, -(math.E - 1), a.PositionX, 1e-5)
+	assert.InDelta(t, 0, b.PositionY, 1e-3)
+	assert.Equal(t, float32(-1), a.ScaleX)
+	assert.Equal(t, float32(0), a.ScaleY)
+	assert.Equal(t, uint8(255), a.RotationW)
+	assert.Equal(t, uint8(255), b.RotationZ)
+	assert.Equal(t, uint8(128), a.ColorR)
+	assert.Equal(t, uint8(200), a.ColorA)
+	assert.Less(t, b.ColorG, b.ColorR)
+	assert.Equal(t, encodeSplatSH(0.5), a.SH1[0])
+	assert.Equal(t, encodeSplatSH(-0.5), b.SH1[8])
+}
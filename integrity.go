@@ -0,0 +1,186 @@
+package spz
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"hash/crc32"
+	"maps"
+)
+
+// MetaKeyChecksum is the metadata key of the embedded payload checksum
+const MetaKeyChecksum = "checksum"
+
+// Checksum holds hex-encoded checksums of the uncompressed payload: the
+// header and data sections without the metadata extension
+type Checksum struct {
+	CRC32  string `json:"crc32"`
+	SHA256 string `json:"sha256"`
+}
+
+func payloadChecksum(payload []byte) Checksum {
+	sum := sha256.Sum256(payload)
+	return Checksum{
+		CRC32:  hex.EncodeToString(binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE(payload))),
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+}
+
+// withChecksum returns a copy of the metadata with the payload checksum added
+func withChecksum(m Metadata, payload []byte) (Metadata, error) {
+	out := maps.Clone(m)
+	if out == nil {
+		out = Metadata{}
+	}
+	if err := out.SetJSON(MetaKeyChecksum, payloadChecksum(payload)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ContentHash returns the hex-encoded SHA-256 of the uncompressed payload
+// of an SPZ stream. It does not depend on the codec, the compression level
+// or the metadata, so it identifies the content of a file. The chunks of a
+// chunked container are joined first, so a container hashes like the single
+// stream of the same cloud. Payloads whose sections do not match their header
+// are rejected, as by Verify.
+func ContentHash(compressedDatas []byte) (string, error) {
+	ungzipDatas, err := decompressPayload(compressedDatas)
+	if err != nil {
+		return "", err
+	}
+	h, datas, _, err := splitSpzPayload(ungzipDatas)
+	if err != nil {
+		return "", err
+	}
+	if _, err := newSpzLayout(datas, h); err != nil {
+		return "", err
+	}
+	return payloadChecksum(ungzipDatas[:HeaderSizeSpz+len(datas)]).SHA256, nil
+}
+
+// GzipCRC32 returns the CRC-32 stored in the trailer of a gzip stream, which
+// covers the whole decompressed stream including the metadata extension
+func GzipCRC32(bts []byte) (uint32, bool) {
+	if c := DetectCompressor(bts); c == nil || c.Name() != "gzip" || len(bts) < 18 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(bts[len(bts)-8:]), true
+}
+
+// IntegrityReport describes the checks run by Verify
+type IntegrityReport struct {
+	// Codec is the name of the detected compression codec
+	Codec string `json:"codec"`
+	// GzipCRC32 is the gzip trailer checksum, verified while decompressing
+	GzipCRC32 string `json:"gzip_crc32,omitempty"`
+	// Computed holds the checksums of the payload as read
+	Computed Checksum `json:"computed"`
+	// Embedded holds the checksums stored in the metadata, if any
+	Embedded *Checksum `json:"embedded,omitempty"`
+	// Valid reports whether the embedded checksums, if any, match
+	Valid bool `json:"valid"`
+	// Chunks holds the reports of the chunks of a chunked container, whose
+	// checksums are embedded per chunk
+	Chunks []*IntegrityReport `json:"chunks,omitempty"`
+}
+
+// ToJSON serializes the report to indented JSON
+func (r *IntegrityReport) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// Verify decompresses an SPZ stream, checks its structure and compares the
+// payload with the checksum embedded in the metadata. Corrupted compressed
+// data and malformed payloads are reported as errors; a checksum mismatch
+// yields a report that is not Valid. The chunks of a chunked container are
+// verified one by one, and the container is Valid if all chunks are.
+func Verify(compressedDatas []byte) (*IntegrityReport, error) {
+	return VerifyWithOptions(compressedDatas, nil)
+}
+
+// VerifyWithOptions is Verify with decoding options: SHCodebook accepts SH
+// codebook payloads and Compressor selects the codec. The other options are
+// ignored.
+func VerifyWithOptions(compressedDatas []byte, opts *DecodeOptions) (*IntegrityReport, error) {
+	if opts == nil {
+		opts = &DecodeOptions{}
+	}
+	if IsChunked(compressedDatas) {
+		return verifyChunked(compressedDatas, opts)
+	}
+	report := &IntegrityReport{Codec: "unknown"}
+	if c := opts.Compressor; c != nil {
+		report.Codec = c.Name()
+	} else if c := DetectCompressor(compressedDatas); c != nil {
+		report.Codec = c.Name()
+	}
+	if crc, ok := GzipCRC32(compressedDatas); ok {
+		report.GzipCRC32 = hex.EncodeToString(binary.BigEndian.AppendUint32(nil, crc))
+	}
+
+	ungzipDatas, err := decompressWith(opts.Compressor, compressedDatas)
+	if err != nil {
+		return nil, err
+	}
+	h, datas, ext, err := splitSpzPayload(ungzipDatas)
+	if err != nil {
+		return nil, err
+	}
+	if h.Flags&FlagSHCodebook != 0 && !opts.SHCodebook {
+		return nil, errSHCodebookDisabled
+	}
+	if _, err := newSpzLayout(datas, h); err != nil {
+		return nil, err
+	}
+	report.Computed = payloadChecksum(ungzipDatas[:HeaderSizeSpz+len(datas)])
+	report.Valid = true
+
+	if ext != nil {
+		m, err := decodeMetadata(ext)
+		if err != nil {
+			return nil, err
+		}
+		embedded := &Checksum{}
+		if ok, err := m.GetJSON(MetaKeyChecksum, embedded); err != nil {
+			return nil, err
+		} else if ok {
+			report.Embedded = embedded
+			report.Valid = *embedded == report.Computed
+		}
+	}
+	return report, nil
+}
+
+// verifyChunked verifies every chunk of a container and checksums the joined
+// payload
+func verifyChunked(bts []byte, opts *DecodeOptions) (*IntegrityReport, error) {
+	chunks, err := ParseChunkIndex(bts)
+	if err != nil {
+		return nil, err
+	}
+	report := &IntegrityReport{Codec: "chunked", Valid: true, Chunks: make([]*IntegrityReport, len(chunks))}
+	for i, c := range chunks {
+		stream, err := chunkStream(bts, c)
+		if err != nil {
+			return nil, err
+		}
+		if report.Chunks[i], err = VerifyWithOptions(stream, opts); err != nil {
+			return nil, err
+		}
+		report.Valid = report.Valid && report.Chunks[i].Valid
+	}
+
+	ungzipDatas, err := inflateChunked(context.Background(), bts)
+	if err != nil {
+		return nil, err
+	}
+	_, datas, _, err := splitSpzPayload(ungzipDatas)
+	if err != nil {
+		return nil, err
+	}
+	report.Computed = payloadChecksum(ungzipDatas[:HeaderSizeSpz+len(datas)])
+	return report, nil
+}
@@ -0,0 +1,95 @@
+package spz
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestContentHash tests that the content hash ignores codec and metadata
+func TestContentHash(t *testing.T) {
+	data := newCompressTestData()
+	hash, err := ContentHash(mustEncode(t, data, NewGzipCompressor(gzip.BestSpeed)))
+	assert.NoError(t, err)
+	assert.Len(t, hash, 64)
+
+	data.Metadata = Metadata{"note": []byte("x")}
+	for _, c := range []Compressor{NewGzipCompressor(gzip.BestCompression), NewZlibCompressor(1), NoneCompressor{}} {
+		other, err := ContentHash(mustEncode(t, data, c))
+		assert.NoError(t, err)
+		assert.Equal(t, hash, other)
+	}
+
+	data.Data[0].ColorA++
+	other, err := ContentHash(mustEncode(t, data, nil))
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, other)
+
+	// Payloads with sections the header does not describe have no hash
+	bts := mustEncode(t, data, NoneCompressor{})
+	binary.LittleEndian.PutUint32(bts[8:], 3)
+	_, err = ContentHash(bts)
+	assert.EqualError(t, err, "Invalid SPZ data: incorrect data size")
+	_, err = Verify(bts)
+	assert.EqualError(t, err, "Invalid SPZ data: incorrect data size")
+}
+
+// TestVerify tests embedded checksums and the gzip trailer
+func TestVerify(t *testing.T) {
+	data := newCompressTestData()
+	data.Metadata = Metadata{"note": []byte("x")}
+
+	bts, err := EncodeWithOptions(data, &EncodeOptions{Checksum: true})
+	assert.NoError(t, err)
+	assert.Len(t, data.Metadata, 1)
+	report, err := Verify(bts)
+	assert.NoError(t, err)
+	assert.Equal(t, "gzip", report.Codec)
+	assert.True(t, report.Valid)
+	assert.NotNil(t, report.Embedded)
+	hash, _ := ContentHash(bts)
+	assert.Equal(t, hash, report.Computed.SHA256)
+
+	crc, ok := GzipCRC32(bts)
+	assert.True(t, ok)
+	raw, err := decompressAuto(bts)
+	assert.NoError(t, err)
+	assert.Equal(t, crc32.ChecksumIEEE(raw), crc)
+
+	// Flip a payload byte of an uncompressed file
+	bts, err = EncodeWithOptions(data, &EncodeOptions{Compressor: NoneCompressor{}, Checksum: true})
+	assert.NoError(t, err)
+	bts[HeaderSizeSpz] ^= 1
+	report, err = Verify(bts)
+	assert.NoError(t, err)
+	assert.False(t, report.Valid)
+	_, ok = GzipCRC32(bts)
+	assert.False(t, ok)
+
+	// Without an embedded checksum the file is valid if well-formed
+	report, err = Verify(mustEncode(t, newCompressTestData(), nil))
+	assert.NoError(t, err)
+	assert.True(t, report.Valid)
+	assert.Nil(t, report.Embedded)
+
+	bts = mustEncode(t, data, nil)
+	bts[len(bts)-6] ^= 1
+	_, err = Verify(bts)
+	assert.Error(t, err)
+
+	// SH codebook payloads and raw DEFLATE streams need the options
+	bts, err = EncodeWithOptions(data, &EncodeOptions{SHCodebookSize: 1, Checksum: true})
+	assert.NoError(t, err)
+	_, err = Verify(bts)
+	assert.ErrorIs(t, err, errSHCodebookDisabled)
+	report, err = VerifyWithOptions(bts, &DecodeOptions{SHCodebook: true})
+	assert.NoError(t, err)
+	assert.True(t, report.Valid)
+	deflate := NewDeflateCompressor(gzip.BestSpeed)
+	report, err = VerifyWithOptions(mustEncode(t, data, deflate), &DecodeOptions{Compressor: deflate})
+	assert.NoError(t, err)
+	assert.Equal(t, "deflate", report.Codec)
+}
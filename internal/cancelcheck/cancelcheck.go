@@ -0,0 +1,18 @@
+// Package cancelcheck paces the cancellation checks of per-point loops, so
+// that long operations notice a canceled context without paying for a check
+// on every point.
+package cancelcheck
+
+import "context"
+
+// Interval is the number of points processed between two cancellation
+// checks in per-point loops
+const Interval = 1 << 12
+
+// Check returns the context error every Interval points
+func Check(ctx context.Context, i int) error {
+	if i%Interval != 0 {
+		return nil
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,184 @@
+package spz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+)
+
+const (
+	// MetadataVersion is the version of the metadata extension layout
+	MetadataVersion = 1
+	// metadataHeaderSize is the size of the magic, version, reserved and
+	// length fields preceding the entries
+	metadataHeaderSize = 12
+)
+
+// metadataMagic opens the metadata extension
+var metadataMagic = []byte("SPZX")
+
+// Well-known metadata keys, whose values are JSON documents
+const (
+	MetaKeyProvenance   = "provenance"
+	MetaKeySceneScale   = "scene_scale"
+	MetaKeyCamera       = "camera"
+	MetaKeyGeoReference = "geo"
+)
+
+// Metadata holds key/value pairs stored in the optional extension section
+// after the SPZ payload:
+//
+//	"SPZX"
+//	uint16 version
+//	uint16 reserved
+//	uint32 length of the entries in bytes
+//	entries: uint16 key length, key, uint32 value length, value
+//
+// Files without metadata carry no extension, so they stay spec-compliant.
+// Readers that do not opt in skip the section.
+type Metadata map[string][]byte
+
+// Provenance describes where a cloud comes from
+type Provenance struct {
+	Source            string `json:"source,omitempty"`
+	TrainingIteration int    `json:"training_iteration,omitempty"`
+	Software          string `json:"software,omitempty"`
+}
+
+// PreferredCamera is the suggested initial view of a cloud
+type PreferredCamera struct {
+	Position [3]float64 `json:"position"`
+	Target   [3]float64 `json:"target"`
+	Up       [3]float64 `json:"up"`
+	// FovY is the vertical field of view in radians
+	FovY float64 `json:"fov_y,omitempty"`
+}
+
+// SetJSON stores v as a JSON value under key
+func (m Metadata) SetJSON(key string, v any) error {
+	bts, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	m[key] = bts
+	return nil
+}
+
+// GetJSON decodes the JSON value under key into v and reports whether the
+// key is present
+func (m Metadata) GetJSON(key string, v any) (bool, error) {
+	bts, ok := m[key]
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(bts, v); err != nil {
+		return true, &SpzError{"Invalid metadata " + key + ": " + err.Error()}
+	}
+	return true, nil
+}
+
+// encodeMetadata serializes metadata into an extension section with keys
+// in sorted order
+func encodeMetadata(m Metadata) ([]byte, error) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		if len(k) > math.MaxUint16 {
+			return nil, &SpzError{"Invalid metadata: key too long"}
+		}
+		if uint64(len(m[k])) > math.MaxUint32 {
+			return nil, &SpzError{"Invalid metadata: value too long"}
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var entries []byte
+	for _, k := range keys {
+		entries = binary.LittleEndian.AppendUint16(entries, uint16(len(k)))
+		entries = append(entries, k...)
+		entries = binary.LittleEndian.AppendUint32(entries, uint32(len(m[k])))
+		entries = append(entries, m[k]...)
+	}
+
+	out := make([]byte, 0, metadataHeaderSize+len(entries))
+	out = append(out, metadataMagic...)
+	out = binary.LittleEndian.AppendUint16(out, MetadataVersion)
+	out = binary.LittleEndian.AppendUint16(out, 0)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(entries)))
+	return append(out, entries...), nil
+}
+
+// isMetadata reports whether ext starts an extension section whose length
+// prefix covers exactly the rest of ext
+func isMetadata(ext []byte) bool {
+	if len(ext) < metadataHeaderSize || !bytes.HasPrefix(ext, metadataMagic) {
+		return false
+	}
+	return uint64(binary.LittleEndian.Uint32(ext[8:])) == uint64(len(ext)-metadataHeaderSize)
+}
+
+// decodeMetadata parses an extension section checked by isMetadata
+func decodeMetadata(ext []byte) (Metadata, error) {
+	if version := binary.LittleEndian.Uint16(ext[4:]); version != MetadataVersion {
+		return nil, &SpzError{"Unsupported metadata version"}
+	}
+	m := Metadata{}
+	entries := ext[metadataHeaderSize:]
+	for len(entries) > 0 {
+		if len(entries) < 2 {
+			return nil, &SpzError{"Invalid metadata: truncated entry"}
+		}
+		keyLen := int(binary.LittleEndian.Uint16(entries))
+		entries = entries[2:]
+		if len(entries) < keyLen+4 {
+			return nil, &SpzError{"Invalid metadata: truncated entry"}
+		}
+		key := string(entries[:keyLen])
+		valueLen := uint64(binary.LittleEndian.Uint32(entries[keyLen:]))
+		entries = entries[keyLen+4:]
+		if uint64(len(entries)) < valueLen {
+			return nil, &SpzError{"Invalid metadata: truncated entry"}
+		}
+		m[key] = bytes.Clone(entries[:valueLen])
+		entries = entries[valueLen:]
+	}
+	return m, nil
+}
+
+// spzPayloadSize returns the size of the data sections, including the SH
+// codebook extension, that precede the metadata extension
+func spzPayloadSize(datas []byte, h *SpzData) int {
+	base := spzBaseDataSize(h)
+	dim := shCoeffsForDegree[min(h.ShDegree, 3)] * 3
+	if h.Flags&FlagSHCodebook != 0 && dim > 0 {
+		if len(datas) < base+4 {
+			return len(datas)
+		}
+		k := int(binary.LittleEndian.Uint32(datas[base:]))
+		return base + 4 + k*dim + int(h.NumPoints)*2
+	}
+	return base + int(h.NumPoints)*dim
+}
+
+// splitSpzPayload parses the header of a decompressed stream and splits the
+// data sections from the metadata extension, if any
+func splitSpzPayload(ungzipDatas []byte) (*SpzData, []byte, []byte, error) {
+	// Check if we have enough data for the header
+	if len(ungzipDatas) < HeaderSizeSpz {
+		return nil, nil, nil, &SpzError{"Invalid SPZ file: insufficient data for header"}
+	}
+
+	// Parse header
+	spzData, err := ParseSpzHeader(ungzipDatas[0:HeaderSizeSpz])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	datas := ungzipDatas[HeaderSizeSpz:]
+	if size := spzPayloadSize(datas, spzData); size < len(datas) && isMetadata(datas[size:]) {
+		return spzData, datas[:size], datas[size:], nil
+	}
+	return spzData, datas, nil, nil
+}
@@ -0,0 +1,63 @@
+package spz
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMetadata tests writing and reading the metadata extension
+func TestMetadata(t *testing.T) {
+	data := newCompressTestData()
+	plain := mustEncode(t, data, NoneCompressor{})
+
+	data.Metadata = Metadata{"note": []byte("hello")}
+	assert.NoError(t, data.Metadata.SetJSON(MetaKeyProvenance, Provenance{Source: "capture-01", TrainingIteration: 30000, Software: "go-spz"}))
+	assert.NoError(t, data.Metadata.SetJSON(MetaKeySceneScale, 0.5))
+	assert.NoError(t, data.Metadata.SetJSON(MetaKeyGeoReference, GeoReference{Latitude: 1, Longitude: 2, Height: 3}))
+	bts := mustEncode(t, data, NoneCompressor{})
+	assert.Equal(t, plain, bts[:len(plain)])
+	assert.Equal(t, []byte("SPZX"), bts[len(plain):len(plain)+4])
+
+	// Without opt-in the extension is skipped
+	decoded, err := DecodeWithOptions(bts, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, decoded.Metadata)
+	assert.Len(t, decoded.Data, 2)
+
+	decoded, err = DecodeWithOptions(bts, &DecodeOptions{Metadata: true})
+	assert.NoError(t, err)
+	assert.Equal(t, data.Metadata, decoded.Metadata)
+	var provenance Provenance
+	ok, err := decoded.Metadata.GetJSON(MetaKeyProvenance, &provenance)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, 30000, provenance.TrainingIteration)
+	var camera PreferredCamera
+	ok, err = decoded.Metadata.GetJSON(MetaKeyCamera, &camera)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	// The extension follows the SH codebook section
+	bts, err = EncodeWithOptions(data, &EncodeOptions{SHCodebookSize: 1})
+	assert.NoError(t, err)
+	decoded, err = DecodeWithOptions(bts, &DecodeOptions{SHCodebook: true, Metadata: true})
+	assert.NoError(t, err)
+	assert.Equal(t, data.Metadata, decoded.Metadata)
+	assert.Equal(t, decoded.Data[0].SH1, decoded.Data[1].SH1)
+
+	// Unknown versions fail only when parsing is requested
+	ext := mustEncode(t, data, NoneCompressor{})
+	ext[len(plain)+4] = 2
+	_, err = DecodeWithOptions(ext, nil)
+	assert.NoError(t, err)
+	_, err = DecodeWithOptions(ext, &DecodeOptions{Metadata: true})
+	assert.Error(t, err)
+
+	// Trailing bytes that are not an extension are still rejected
+	_, err = Decode(append(plain, 1, 2, 3))
+	assert.Error(t, err)
+	truncated := mustEncode(t, data, NoneCompressor{})
+	_, err = Decode(truncated[:len(truncated)-1])
+	assert.Error(t, err)
+}
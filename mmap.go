@@ -0,0 +1,147 @@
+package spz
+
+import (
+	"os"
+	"strconv"
+)
+
+// MappedSpz gives zero-copy access to the sections of an uncompressed SPZ
+// file mapped into memory. On platforms without mmap support, and for
+// compressed files and chunked containers, the payload is read into memory
+// instead. The column
+// slices are only valid until Close; after Close the accessors return nil
+// and Splat returns an error.
+type MappedSpz struct {
+	// Header holds the header fields and metadata; Data is not populated
+	Header *SpzData
+
+	layout *spzLayout
+	unmap  func() error
+}
+
+// OpenMapped opens an SPZ file for zero-copy column access
+func OpenMapped(file string) (*MappedSpz, error) {
+	bts, unmap, err := mapFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := newMappedSpz(bts, unmap)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	return m, nil
+}
+
+func newMappedSpz(bts []byte, unmap func() error) (*MappedSpz, error) {
+	if !(NoneCompressor{}).Match(bts) {
+		// Compressed files and chunked containers gain nothing from the mapping
+		ungzipDatas, err := decompressPayload(bts)
+		if err != nil {
+			return nil, err
+		}
+		if err := unmap(); err != nil {
+			return nil, err
+		}
+		bts, unmap = ungzipDatas, func() error { return nil }
+	}
+
+	h, datas, ext, err := splitSpzPayload(bts)
+	if err != nil {
+		return nil, err
+	}
+	if ext != nil {
+		if h.Metadata, err = decodeMetadata(ext); err != nil {
+			return nil, err
+		}
+	}
+	layout, err := newSpzLayout(datas, h)
+	if err != nil {
+		return nil, err
+	}
+	return &MappedSpz{Header: h, layout: layout, unmap: unmap}, nil
+}
+
+// readFile is the fallback of mapFile
+func readFile(file string) ([]byte, func() error, error) {
+	bts, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bts, func() error { return nil }, nil
+}
+
+// Close releases the mapping
+func (m *MappedSpz) Close() error {
+	unmap := m.unmap
+	m.unmap, m.layout = func() error { return nil }, nil
+	return unmap()
+}
+
+// Len returns the number of splats
+func (m *MappedSpz) Len() int {
+	return int(m.Header.NumPoints)
+}
+
+// Positions returns the position section: x, y, z per splat as 24-bit
+// little-endian fixed-point values
+func (m *MappedSpz) Positions() []byte {
+	if m.layout == nil {
+		return nil
+	}
+	return m.layout.positions
+}
+
+// Alphas returns the alpha section: one opacity byte per splat
+func (m *MappedSpz) Alphas() []byte {
+	if m.layout == nil {
+		return nil
+	}
+	return m.layout.alphas
+}
+
+// Colors returns the color section: encoded r, g, b bytes per splat
+func (m *MappedSpz) Colors() []byte {
+	if m.layout == nil {
+		return nil
+	}
+	return m.layout.colors
+}
+
+// Scales returns the scale section: encoded log scales, 3 bytes per splat
+func (m *MappedSpz) Scales() []byte {
+	if m.layout == nil {
+		return nil
+	}
+	return m.layout.scales
+}
+
+// Rotations returns the rotation section: 3 bytes per splat in version 2
+// and 4 bytes (smallest-three) in version 3
+func (m *MappedSpz) Rotations() []byte {
+	if m.layout == nil {
+		return nil
+	}
+	return m.layout.rotations
+}
+
+// SH returns the SH section: 0, 9, 24 or 45 bytes per splat depending on
+// the degree. It is nil for files in the SH codebook mode.
+func (m *MappedSpz) SH() []byte {
+	if m.layout == nil {
+		return nil
+	}
+	return m.layout.shs
+}
+
+// Splat decodes splat i
+func (m *MappedSpz) Splat(i int) (*SplatData, error) {
+	if m.layout == nil {
+		return nil, &SpzError{"Mapped SPZ file is closed"}
+	}
+	if i < 0 || i >= m.Len() {
+		return nil, &SpzError{"Invalid splat index: " + strconv.Itoa(i)}
+	}
+	return m.layout.splat(i, MaskAll), nil
+}
@@ -0,0 +1,32 @@
+//go:build linux
+
+package spz
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps a file read-only into memory
+func mapFile(file string) ([]byte, func() error, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	size := info.Size()
+	if size == 0 || int64(int(size)) != size {
+		return readFile(file)
+	}
+
+	bts, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return readFile(file)
+	}
+	return bts, func() error { return syscall.Munmap(bts) }, nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package spz
+
+// mapFile reads the file into memory where mmap is not supported
+func mapFile(file string) ([]byte, func() error, error) {
+	return readFile(file)
+}
@@ -0,0 +1,53 @@
+package spz
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestOpenMapped tests zero-copy access to uncompressed and compressed files
+func TestOpenMapped(t *testing.T) {
+	data := newCompressTestData()
+	data.Metadata = Metadata{"note": []byte("x")}
+	dir := t.TempDir()
+
+	for _, c := range []Compressor{NoneCompressor{}, DefaultCompressor} {
+		file := filepath.Join(dir, c.Name()+".spz")
+		assert.NoError(t, WriteSpzWithCompressor(file, data, c))
+		full, err := ReadSpz(file)
+		assert.NoError(t, err)
+
+		m, err := OpenMapped(file)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, m.Len())
+		assert.Equal(t, data.Metadata, m.Header.Metadata)
+		assert.Len(t, m.Positions(), 18)
+		assert.Equal(t, []byte{200, 180}, m.Alphas())
+		assert.Len(t, m.Colors(), 6)
+		assert.Len(t, m.Scales(), 6)
+		assert.Len(t, m.Rotations(), 8)
+		assert.Len(t, m.SH(), 18)
+		splat, err := m.Splat(1)
+		assert.NoError(t, err)
+		assert.Equal(t, full.Data[1], splat)
+		_, err = m.Splat(2)
+		assert.Error(t, err)
+		_, err = m.Splat(-1)
+		assert.Error(t, err)
+
+		assert.NoError(t, m.Close())
+		assert.NoError(t, m.Close())
+		assert.Nil(t, m.Positions())
+		assert.Nil(t, m.SH())
+		_, err = m.Splat(0)
+		assert.Error(t, err)
+	}
+
+	bts := mustEncode(t, data, NoneCompressor{})
+	_, err := newMappedSpz(bts[:len(bts)-40], func() error { return nil })
+	assert.Error(t, err)
+	_, err = OpenMapped(filepath.Join(dir, "missing.spz"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,143 @@
+package spz
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// plyTypeSizes maps PLY scalar types to their size in bytes
+var plyTypeSizes = map[string]int{
+	"char": 1, "int8": 1, "uchar": 1, "uint8": 1,
+	"short": 2, "int16": 2, "ushort": 2, "uint16": 2,
+	"int": 4, "int32": 4, "uint": 4, "uint32": 4,
+	"float": 4, "float32": 4, "double": 8, "float64": 8,
+}
+
+// plyProperty is a scalar property of a PLY element
+type plyProperty struct {
+	name   string
+	typ    string
+	offset int
+}
+
+// plyElement is an element of a binary little endian PLY file with its rows
+type plyElement struct {
+	name   string
+	count  int
+	stride int
+	props  []plyProperty
+	data   []byte
+}
+
+// readPly parses the header and element data of a binary little endian PLY
+func readPly(r io.Reader) ([]*plyElement, error) {
+	br := bufio.NewReader(r)
+
+	line, err := br.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "ply" {
+		return nil, &SpzError{"Invalid PLY file: missing magic"}
+	}
+
+	var elements []*plyElement
+	for {
+		line, err = br.ReadString('\n')
+		if err != nil {
+			return nil, &SpzError{"Invalid PLY file: truncated header"}
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "format":
+			if len(fields) < 2 || fields[1] != "binary_little_endian" {
+				return nil, &SpzError{"Unsupported PLY format: only binary_little_endian is supported"}
+			}
+		case "element":
+			if len(fields) != 3 {
+				return nil, &SpzError{"Invalid PLY file: malformed element"}
+			}
+			count, err := strconv.Atoi(fields[2])
+			if err != nil || count < 0 {
+				return nil, &SpzError{"Invalid PLY file: malformed element count"}
+			}
+			elements = append(elements, &plyElement{name: fields[1], count: count})
+		case "property":
+			if len(elements) == 0 || len(fields) != 3 {
+				return nil, &SpzError{"Invalid PLY file: unsupported property " + strings.TrimSpace(line)}
+			}
+			size, ok := plyTypeSizes[fields[1]]
+			if !ok {
+				return nil, &SpzError{"Invalid PLY file: unsupported property type " + fields[1]}
+			}
+			e := elements[len(elements)-1]
+			e.props = append(e.props, plyProperty{name: fields[2], typ: fields[1], offset: e.stride})
+			e.stride += size
+		case "end_header":
+			rest, err := io.ReadAll(br)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range elements {
+				if e.stride > 0 && e.count > len(rest)/e.stride {
+					return nil, &SpzError{"Invalid PLY file: truncated " + e.name + " data"}
+				}
+				e.data, rest = rest[:e.count*e.stride], rest[e.count*e.stride:]
+			}
+			return elements, nil
+		}
+	}
+}
+
+// findPlyElement returns the element with the given name, or nil
+func findPlyElement(elements []*plyElement, name string) *plyElement {
+	for _, e := range elements {
+		if e.name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// property returns the property with the given name, or nil
+func (e *plyElement) property(name string) *plyProperty {
+	for i := range e.props {
+		if e.props[i].name == name {
+			return &e.props[i]
+		}
+	}
+	return nil
+}
+
+// value reads a property of a row as float64
+func (e *plyElement) value(row int, p *plyProperty) float64 {
+	b := e.data[row*e.stride+p.offset:]
+	switch p.typ {
+	case "char", "int8":
+		return float64(int8(b[0]))
+	case "uchar", "uint8":
+		return float64(b[0])
+	case "short", "int16":
+		return float64(int16(binary.LittleEndian.Uint16(b)))
+	case "ushort", "uint16":
+		return float64(binary.LittleEndian.Uint16(b))
+	case "int", "int32":
+		return float64(int32(binary.LittleEndian.Uint32(b)))
+	case "uint", "uint32":
+		return float64(binary.LittleEndian.Uint32(b))
+	case "float", "float32":
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	default:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+}
+
+// uint32Value reads a 32-bit unsigned property of a row
+func (e *plyElement) uint32Value(row int, p *plyProperty) uint32 {
+	return binary.LittleEndian.Uint32(e.data[row*e.stride+p.offset:])
+}
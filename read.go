@@ -1,40 +1,61 @@
 package spz
 
 import (
+	"context"
+	"encoding/binary"
 	"io"
 	"os"
+	"strconv"
+
+	"github.com/flywave/go-spz/internal/cancelcheck"
 )
 
-// readSpzDatas parses the data section of an SPZ file
-func readSpzDatas(datas []byte, h *SpzData) ([]*SplatData, error) {
-	// Calculate sizes for each data section
-	positionSize := int(h.NumPoints) * 9 // 3 bytes per axis * 3 axes
-	alphaSize := int(h.NumPoints)        // 1 byte per point
-	colorSize := int(h.NumPoints) * 3    // 1 byte per channel * 3 channels
-	scaleSize := int(h.NumPoints) * 3    // 1 byte per axis * 3 axes
-	rotationSize := int(h.NumPoints) * 3 // Version 2: 3 bytes
+// spzLayout locates the sections of the data following the header. Every
+// section has fixed-size records, so any splat can be decoded on its own.
+type spzLayout struct {
+	h            *SpzData
+	rotationSize int
+	shDim        int
+	// legacy decodes colors and rotations as go-spz releases before the
+	// reference encoding wrote them
+	legacy bool
+
+	positions []byte
+	alphas    []byte
+	colors    []byte
+	scales    []byte
+	rotations []byte
+	shs       []byte
+
+	// palette and indices replace shs in the SH codebook mode
+	palette []byte
+	indices []byte
+}
+
+// newSpzLayout splits the data sections and validates their size
+func newSpzLayout(datas []byte, h *SpzData) (*spzLayout, error) {
+	n := int(h.NumPoints)
+	l := &spzLayout{h: h, rotationSize: 3} // Version 2: 3 bytes
 	if h.Version >= 3 {
-		rotationSize = int(h.NumPoints) * 4 // Version 3: 4 bytes
+		l.rotationSize = 4 // Version 3: 4 bytes
 	}
 
 	// Calculate SH data size
-	shDim := 0
 	switch h.ShDegree {
 	case 1:
-		shDim = int(h.NumPoints) * 9
+		l.shDim = 9
 	case 2:
-		shDim = int(h.NumPoints) * 24
+		l.shDim = 24
 	case 3:
-		shDim = int(h.NumPoints) * 45
+		l.shDim = 45
 	}
 
-	// Calculate expected data size
-	expectedSize := positionSize + alphaSize + colorSize + scaleSize + rotationSize + shDim
-
-	// Validate data size
-	if len(datas) != expectedSize {
-		return nil, &SpzError{"Invalid SPZ data: incorrect data size"}
-	}
+	// Calculate sizes for each data section
+	positionSize := n * 9 // 3 bytes per axis * 3 axes
+	alphaSize := n        // 1 byte per point
+	colorSize := n * 3    // 1 byte per channel * 3 channels
+	scaleSize := n * 3    // 1 byte per axis * 3 axes
+	rotationSize := n * l.rotationSize
 
 	// Calculate offsets (matching write order)
 	offsetPositions := 0
@@ -44,88 +65,303 @@ func readSpzDatas(datas []byte, h *SpzData) ([]*SplatData, error) {
 	offsetRotations := offsetScales + scaleSize
 	offsetShs := offsetRotations + rotationSize
 
+	// Validate data size
+	codebook := h.Flags&FlagSHCodebook != 0 && l.shDim > 0
+	if codebook {
+		if len(datas) < offsetShs+4 {
+			return nil, &SpzError{"Invalid SPZ data: truncated SH codebook"}
+		}
+		k := int(binary.LittleEndian.Uint32(datas[offsetShs:]))
+		if k == 0 || k > MaxSHCodebookSize || len(datas) != offsetShs+4+k*l.shDim+n*2 {
+			return nil, &SpzError{"Invalid SPZ data: incorrect SH codebook size"}
+		}
+		l.palette = datas[offsetShs+4 : offsetShs+4+k*l.shDim]
+		l.indices = datas[offsetShs+4+k*l.shDim:]
+		for i := range n {
+			if int(binary.LittleEndian.Uint16(l.indices[i*2:])) >= k {
+				return nil, &SpzError{"Invalid SPZ data: SH codebook index out of range"}
+			}
+		}
+	} else if len(datas) != offsetShs+n*l.shDim {
+		return nil, &SpzError{"Invalid SPZ data: incorrect data size"}
+	}
+
 	// Extract data sections
-	positions := datas[offsetPositions:offsetAlphas]
-	alphas := datas[offsetAlphas:offsetColors]
-	colors := datas[offsetColors:offsetScales]
-	scales := datas[offsetScales:offsetRotations]
-	rotations := datas[offsetRotations:offsetShs]
-	shs := datas[offsetShs:]
+	l.positions = datas[offsetPositions:offsetAlphas]
+	l.alphas = datas[offsetAlphas:offsetColors]
+	l.colors = datas[offsetColors:offsetScales]
+	l.scales = datas[offsetScales:offsetRotations]
+	l.rotations = datas[offsetRotations:offsetShs]
+	if !codebook {
+		l.shs = datas[offsetShs:]
+	}
+	return l, nil
+}
 
-	// Parse each splat data point
-	var splatDatas []*SplatData
-	for i := range int(h.NumPoints) {
-		data := &SplatData{}
+// sh returns the encoded SH record of splat i
+func (l *spzLayout) sh(i int) []byte {
+	if l.indices != nil {
+		idx := int(binary.LittleEndian.Uint16(l.indices[i*2:]))
+		return l.palette[idx*l.shDim : (idx+1)*l.shDim]
+	}
+	return l.shs[i*l.shDim : (i+1)*l.shDim]
+}
 
-		// Decode positions (3 bytes each)
+// splat decodes the attributes of splat i selected by mask
+func (l *spzLayout) splat(i int, mask DecodeMask) *SplatData {
+	h := l.h
+	data := &SplatData{}
+
+	// Decode positions (3 bytes each)
+	if mask&MaskPositions != 0 {
+		positions := l.positions
 		data.PositionX = spzDecodePosition(positions[i*9:i*9+3], h.FractionalBits)
 		data.PositionY = spzDecodePosition(positions[i*9+3:i*9+6], h.FractionalBits)
 		data.PositionZ = spzDecodePosition(positions[i*9+6:i*9+9], h.FractionalBits)
+	}
 
-		// Decode alpha (1 byte)
-		data.ColorA = alphas[i]
+	// Decode alpha (1 byte)
+	if mask&MaskAlpha != 0 {
+		data.ColorA = l.alphas[i]
+	}
 
-		// Decode colors (1 byte each, with decoding)
-		data.ColorR = spzDecodeColor(colors[i*3])
-		data.ColorG = spzDecodeColor(colors[i*3+1])
-		data.ColorB = spzDecodeColor(colors[i*3+2])
+	// Decode colors (1 byte each, with decoding)
+	if mask&MaskColor != 0 {
+		colors := l.colors
+		decodeColor := spzDecodeColor
+		if l.legacy {
+			decodeColor = spzDecodeColorLegacy
+		}
+		data.ColorR = decodeColor(colors[i*3])
+		data.ColorG = decodeColor(colors[i*3+1])
+		data.ColorB = decodeColor(colors[i*3+2])
+	}
 
-		// Decode scales (1 byte each)
+	// Decode scales (1 byte each)
+	if mask&MaskScales != 0 {
+		scales := l.scales
 		data.ScaleX = spzDecodeScale(scales[i*3])
 		data.ScaleY = spzDecodeScale(scales[i*3+1])
 		data.ScaleZ = spzDecodeScale(scales[i*3+2])
+	}
 
-		// Decode rotations (version dependent)
-		if h.Version >= 3 {
+	// Decode rotations (version dependent)
+	if mask&MaskRotations != 0 {
+		rotations := l.rotations
+		switch {
+		case h.Version >= 3 && l.legacy:
+			data.RotationW, data.RotationX, data.RotationY, data.RotationZ = spzDecodeRotationsV3Legacy(rotations[i*4 : i*4+4])
+		case h.Version >= 3:
 			data.RotationW, data.RotationX, data.RotationY, data.RotationZ = spzDecodeRotationsV3(rotations[i*4 : i*4+4])
-		} else {
+		case l.legacy:
+			data.RotationW, data.RotationX, data.RotationY, data.RotationZ = spzDecodeRotationsLegacy(rotations[i*3], rotations[i*3+1], rotations[i*3+2])
+		default:
 			data.RotationW, data.RotationX, data.RotationY, data.RotationZ = spzDecodeRotations(rotations[i*3], rotations[i*3+1], rotations[i*3+2])
 		}
+	}
 
-		// Decode SH data (if present)
-		switch h.ShDegree {
-		case 1:
-			if i*9+9 <= len(shs) {
-				data.SH1 = make([]byte, 9)
-				for j := 0; j < 9; j++ {
-					data.SH1[j] = spzDecodeSH1(shs[i*9+j])
-				}
-			}
-		case 2:
-			if i*24+24 <= len(shs) {
-				data.SH2 = make([]byte, 24)
-				for j := 0; j < 9; j++ {
-					data.SH2[j] = spzDecodeSH1(shs[i*24+j])
-				}
-				for j := 9; j < 24; j++ {
-					data.SH2[j] = spzDecodeSH23(shs[i*24+j])
-				}
-			}
-		case 3:
-			if i*45+45 <= len(shs) {
-				data.SH2 = make([]byte, 24)
-				for j := 0; j < 9; j++ {
-					data.SH2[j] = spzDecodeSH1(shs[i*45+j])
-				}
-				for j := 9; j < 24; j++ {
-					data.SH2[j] = spzDecodeSH23(shs[i*45+j])
-				}
-
-				data.SH3 = make([]byte, 21)
-				for j := 0; j < 21; j++ {
-					data.SH3[j] = spzDecodeSH23(shs[i*45+24+j])
-				}
+	// Decode SH data (if present)
+	if l.shDim == 0 || mask&MaskSH == 0 {
+		return data
+	}
+	shs := l.sh(i)
+	switch h.ShDegree {
+	case 1:
+		data.SH1 = make([]byte, 9)
+		for j := 0; j < 9; j++ {
+			data.SH1[j] = spzDecodeSH1(shs[j])
+		}
+	case 2, 3:
+		data.SH2 = make([]byte, 24)
+		for j := 0; j < 9; j++ {
+			data.SH2[j] = spzDecodeSH1(shs[j])
+		}
+		for j := 9; j < 24; j++ {
+			data.SH2[j] = spzDecodeSH23(shs[j])
+		}
+		if h.ShDegree == 3 {
+			data.SH3 = make([]byte, 21)
+			for j := 0; j < 21; j++ {
+				data.SH3[j] = spzDecodeSH23(shs[24+j])
 			}
 		}
+	}
+	return data
+}
 
-		splatDatas = append(splatDatas, data)
+// readSpzDatas parses the data section of an SPZ file, decoding the
+// attributes selected by opts, which may be nil
+func readSpzDatas(ctx context.Context, datas []byte, h *SpzData, opts *DecodeOptions) ([]*SplatData, error) {
+	l, err := newSpzLayout(datas, h)
+	if err != nil {
+		return nil, err
 	}
+	l.legacy = opts != nil && opts.LegacyEncoding
+	mask := opts.mask()
 
+	// Parse each splat data point
+	splatDatas := make([]*SplatData, 0, h.NumPoints)
+	for i := range int(h.NumPoints) {
+		if err := cancelcheck.Check(ctx, i); err != nil {
+			return nil, err
+		}
+		splatDatas = append(splatDatas, l.splat(i, mask))
+	}
 	return splatDatas, nil
 }
 
+// DecompressSpz decompresses an SPZ stream and returns its header, without
+// splats, and the data sections that follow it, for use with DecodeRange and
+// DecodeIndices. The metadata extension is skipped. The chunks of a chunked
+// container are joined into the sections of a single stream.
+func DecompressSpz(compressedDatas []byte) (*SpzData, []byte, error) {
+	return DecompressSpzWithOptions(compressedDatas, nil)
+}
+
+// DecompressSpzWithOptions is DecompressSpz with optional decoding features:
+// Compressor selects the codec and Metadata parses the metadata extension
+// into the header. The other options apply to DecodeRange and DecodeIndices.
+func DecompressSpzWithOptions(compressedDatas []byte, opts *DecodeOptions) (*SpzData, []byte, error) {
+	if opts == nil {
+		opts = &DecodeOptions{}
+	}
+	var ungzipDatas []byte
+	var err error
+	if IsChunked(compressedDatas) {
+		ungzipDatas, err = inflateChunked(context.Background(), compressedDatas)
+	} else {
+		ungzipDatas, err = decompressWith(opts.Compressor, compressedDatas)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	spzData, datas, ext, err := splitSpzPayload(ungzipDatas)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ext != nil && opts.Metadata {
+		if spzData.Metadata, err = decodeMetadata(ext); err != nil {
+			return nil, nil, err
+		}
+	}
+	return spzData, datas, nil
+}
+
+// DecodeRange decodes the splats [start, end) of the data sections returned
+// by DecompressSpz, without decoding the others. opts selects the attributes
+// and enables the SH codebook mode as for DecodeWithOptions; Metadata is
+// ignored.
+func DecodeRange(payload []byte, header *SpzData, start, end int, opts *DecodeOptions) ([]*SplatData, error) {
+	if start < 0 || end > int(header.NumPoints) || start > end {
+		return nil, &SpzError{"Invalid splat range"}
+	}
+	l, err := newOptionsLayout(payload, header, opts)
+	if err != nil {
+		return nil, err
+	}
+	mask := opts.mask()
+	splatDatas := make([]*SplatData, 0, end-start)
+	for i := start; i < end; i++ {
+		splatDatas = append(splatDatas, l.splat(i, mask))
+	}
+	return splatDatas, nil
+}
+
+// DecodeIndices decodes the splats at the given indices, in that order, of
+// the data sections returned by DecompressSpz. opts is interpreted as by
+// DecodeRange.
+func DecodeIndices(payload []byte, header *SpzData, indices []int, opts *DecodeOptions) ([]*SplatData, error) {
+	for _, i := range indices {
+		if i < 0 || i >= int(header.NumPoints) {
+			return nil, &SpzError{"Invalid splat index: " + strconv.Itoa(i)}
+		}
+	}
+	l, err := newOptionsLayout(payload, header, opts)
+	if err != nil {
+		return nil, err
+	}
+	mask := opts.mask()
+	splatDatas := make([]*SplatData, 0, len(indices))
+	for _, i := range indices {
+		splatDatas = append(splatDatas, l.splat(i, mask))
+	}
+	return splatDatas, nil
+}
+
+// newOptionsLayout checks the SH codebook opt-in of opts before splitting
+// the data sections
+func newOptionsLayout(payload []byte, header *SpzData, opts *DecodeOptions) (*spzLayout, error) {
+	if header.Flags&FlagSHCodebook != 0 && (opts == nil || !opts.SHCodebook) {
+		return nil, errSHCodebookDisabled
+	}
+	l, err := newSpzLayout(payload, header)
+	if err != nil {
+		return nil, err
+	}
+	l.legacy = opts != nil && opts.LegacyEncoding
+	return l, nil
+}
+
+// DecodeOptions controls optional decoding features
+type DecodeOptions struct {
+	// SHCodebook enables decoding of the experimental SH codebook mode
+	SHCodebook bool
+	// Metadata enables parsing of the metadata extension into
+	// SpzData.Metadata; otherwise the extension is skipped
+	Metadata bool
+	// Mask selects the attributes to decode; the others are left zero. The
+	// payload size is validated either way. Zero selects MaskAll.
+	Mask DecodeMask
+	// LegacyEncoding decodes files written by go-spz releases before the
+	// reference encoding: colors stored with a color scale of 2.0 and
+	// version 3 rotations in w, x, y, z order. Such files cannot be told
+	// apart from the header.
+	LegacyEncoding bool
+	// Compressor decompresses the stream instead of the codec detected from
+	// its magic bytes. Raw DEFLATE streams, which have none, need it.
+	Compressor Compressor
+}
+
+// mask returns the attributes selected by opts, which may be nil
+func (opts *DecodeOptions) mask() DecodeMask {
+	if opts == nil || opts.Mask == 0 {
+		return MaskAll
+	}
+	return opts.Mask
+}
+
+// errSHCodebookDisabled rejects SH codebook payloads unless
+// DecodeOptions.SHCodebook is set
+var errSHCodebookDisabled = &SpzError{"Unsupported SPZ data: SH codebook mode is not enabled"}
+
+// DecodeMask is a set of splat attributes to decode
+type DecodeMask uint8
+
+// Attributes selectable in a DecodeMask
+const (
+	MaskPositions DecodeMask = 1 << iota
+	MaskScales
+	MaskRotations
+	MaskAlpha
+	MaskColor
+	MaskSH
+
+	MaskAll = MaskPositions | MaskScales | MaskRotations | MaskAlpha | MaskColor | MaskSH
+)
+
 // ReadSpz reads an SPZ file and returns its header and data
 func ReadSpz(file string) (*SpzData, error) {
+	return ReadSpzWithOptions(file, nil)
+}
+
+// ReadSpzWithOptions reads an SPZ file with optional decoding features
+func ReadSpzWithOptions(file string, opts *DecodeOptions) (*SpzData, error) {
+	return ReadSpzContext(context.Background(), file, opts)
+}
+
+// ReadSpzContext reads an SPZ file, stopping with the context error when ctx
+// is canceled
+func ReadSpzContext(ctx context.Context, file string, opts *DecodeOptions) (*SpzData, error) {
 	f, err := os.Open(file)
 	if err != nil {
 		return nil, err
@@ -133,41 +369,63 @@ func ReadSpz(file string) (*SpzData, error) {
 	defer f.Close()
 
 	// Read all data
-	gzipDatas, err := io.ReadAll(f)
+	compressedDatas, err := io.ReadAll(f)
 	if err != nil {
 		return nil, err
 	}
 
-	// Decompress gzip data
-	ungzipDatas, err := decompressGzip(gzipDatas)
-	if err != nil {
-		// If decompression fails, assume the data is not compressed
-		ungzipDatas = gzipDatas
-	}
+	return DecodeContext(ctx, compressedDatas, opts)
+}
 
-	// Check if we have enough data for the header
-	if len(ungzipDatas) < HeaderSizeSpz {
-		return nil, &SpzError{"Invalid SPZ file: insufficient data for header"}
-	}
+// Decode decodes an SPZ stream, detecting the compression codec from its
+// magic bytes
+func Decode(compressedDatas []byte) (*SpzData, error) {
+	return DecodeWithOptions(compressedDatas, nil)
+}
 
-	// Parse header
-	spzData, err := ParseSpzHeader(ungzipDatas[0:HeaderSizeSpz])
-	if err != nil {
+// DecodeWithOptions decodes an SPZ stream with optional decoding features
+func DecodeWithOptions(compressedDatas []byte, opts *DecodeOptions) (*SpzData, error) {
+	return DecodeContext(context.Background(), compressedDatas, opts)
+}
+
+// DecodeContext decodes an SPZ stream with optional decoding features. It
+// checks ctx between stages and periodically while decoding splats, and
+// returns the context error once ctx is canceled.
+func DecodeContext(ctx context.Context, compressedDatas []byte, opts *DecodeOptions) (*SpzData, error) {
+	if opts == nil {
+		opts = &DecodeOptions{}
+	}
+	if IsChunked(compressedDatas) {
+		return DecodeChunkedContext(ctx, compressedDatas, opts)
+	}
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
-	if spzData == nil {
-		return nil, &SpzError{"Failed to parse SPZ header"}
+	// Decompress data
+	ungzipDatas, err := decompressWith(opts.Compressor, compressedDatas)
+	if err != nil {
+		return nil, err
 	}
 
-	// Parse data
-	if len(ungzipDatas) > HeaderSizeSpz {
-		spzData.Data, err = readSpzDatas(ungzipDatas[HeaderSizeSpz:], spzData)
+	spzData, datas, ext, err := splitSpzPayload(ungzipDatas)
+	if err != nil {
+		return nil, err
+	}
+	if ext != nil && opts.Metadata {
+		spzData.Metadata, err = decodeMetadata(ext)
 		if err != nil {
 			return nil, err
 		}
-	} else {
-		spzData.Data = []*SplatData{}
+	}
+	if spzData.Flags&FlagSHCodebook != 0 && !opts.SHCodebook {
+		return nil, errSHCodebookDisabled
 	}
 
+	// Parse data; the layout rejects data sections shorter than NumPoints
+	// requires, including none at all
+	spzData.Data, err = readSpzDatas(ctx, datas, spzData, opts)
+	if err != nil {
+		return nil, err
+	}
 	return spzData, nil
 }
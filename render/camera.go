@@ -0,0 +1,92 @@
+package render
+
+import (
+	"math"
+
+	spz "github.com/flywave/go-spz"
+)
+
+// Camera is a pinhole camera looking from Position towards Target
+type Camera struct {
+	Position [3]float64
+	Target   [3]float64
+	Up       [3]float64
+	// FovY is the vertical field of view in radians
+	FovY   float64
+	Width  int
+	Height int
+	// Near is the distance below which splats are culled
+	Near float64
+}
+
+// FitCamera returns a camera that frames all splat centers, looking down the
+// negative Z axis from in front of the scene
+func FitCamera(data *spz.SpzData, width, height int) Camera {
+	cam := Camera{
+		Up:     [3]float64{0, 1, 0},
+		FovY:   math.Pi / 3,
+		Width:  width,
+		Height: height,
+		Near:   0.01,
+	}
+	if len(data.Data) == 0 {
+		cam.Position = [3]float64{0, 0, 1}
+		return cam
+	}
+
+	lo := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	hi := [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for _, d := range data.Data {
+		p := [3]float64{float64(d.PositionX), float64(d.PositionY), float64(d.PositionZ)}
+		for i := range 3 {
+			lo[i] = math.Min(lo[i], p[i])
+			hi[i] = math.Max(hi[i], p[i])
+		}
+	}
+
+	radius := 0.0
+	for i := range 3 {
+		cam.Target[i] = (lo[i] + hi[i]) / 2
+		radius = math.Max(radius, (hi[i]-lo[i])/2)
+	}
+	radius = math.Max(radius, 1e-3)
+
+	// Distance at which the bounding sphere fits the narrower field of view
+	fov := cam.FovY
+	if width < height {
+		fov = 2 * math.Atan(math.Tan(cam.FovY/2)*float64(width)/float64(height))
+	}
+	dist := radius*math.Sqrt(3)/math.Sin(fov/2) + radius
+	cam.Position = [3]float64{cam.Target[0], cam.Target[1], cam.Target[2] + dist}
+	cam.Near = math.Max(cam.Near, dist*1e-4)
+	return cam
+}
+
+// view returns the world-to-camera basis (right, up, forward) and the focal length in pixels
+func (c *Camera) view() ([3][3]float64, float64) {
+	f := normalize(sub(c.Target, c.Position))
+	r := normalize(cross(f, c.Up))
+	u := cross(r, f)
+	focal := float64(c.Height) / 2 / math.Tan(c.FovY/2)
+	return [3][3]float64{r, u, f}, focal
+}
+
+func sub(a, b [3]float64) [3]float64 {
+	return [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func dot(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func normalize(a [3]float64) [3]float64 {
+	l := math.Sqrt(dot(a, a))
+	if l == 0 {
+		return a
+	}
+	return [3]float64{a[0] / l, a[1] / l, a[2] / l}
+}
@@ -0,0 +1,205 @@
+// Package render rasterizes SPZ Gaussian splats on the CPU, for thumbnails
+// and previews on machines without a GPU.
+package render
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	spz "github.com/flywave/go-spz"
+	"github.com/flywave/go-spz/internal/cancelcheck"
+)
+
+const (
+	// lowPassVariance is added to the projected covariance so that splats
+	// cover at least about one pixel (EWA anti-aliasing filter)
+	lowPassVariance = 0.3
+	// maxAlpha bounds the opacity of a single splat at a pixel
+	maxAlpha = 0.99
+	// minAlpha is the contribution below which a splat is skipped at a pixel
+	minAlpha = 1.0 / 255.0
+	// minTransmittance stops blending once a pixel is saturated
+	minTransmittance = 1e-4
+)
+
+// Options controls rasterization
+type Options struct {
+	// Background is composited behind the splats
+	Background color.RGBA
+}
+
+// projected is a splat projected to screen space
+type projected struct {
+	x, y   float64
+	depth  float64
+	conic  [3]float64 // inverse 2D covariance: a, b, c
+	radius float64
+	color  [3]float64
+	alpha  float64
+}
+
+// Render projects the splats through the camera, sorts them by depth and
+// alpha-blends their EWA footprints front to back
+func Render(data *spz.SpzData, cam Camera, opts *Options) *image.RGBA {
+	img, _ := RenderContext(context.Background(), data, cam, opts)
+	return img
+}
+
+// RenderContext is Render with cancellation: it returns the context error
+// once ctx is canceled
+func RenderContext(ctx context.Context, data *spz.SpzData, cam Camera, opts *Options) (*image.RGBA, error) {
+	if opts == nil {
+		opts = &Options{Background: color.RGBA{A: 255}}
+	}
+
+	splats, err := project(ctx, data, cam)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(splats, func(i, j int) bool { return splats[i].depth < splats[j].depth })
+
+	w, h := cam.Width, cam.Height
+	accum := make([][3]float64, w*h)
+	trans := make([]float64, w*h)
+	for i := range trans {
+		trans[i] = 1
+	}
+
+	for i, s := range splats {
+		if err := cancelcheck.Check(ctx, i); err != nil {
+			return nil, err
+		}
+		x0 := max(0, int(math.Floor(s.x-s.radius)))
+		x1 := min(w-1, int(math.Ceil(s.x+s.radius)))
+		y0 := max(0, int(math.Floor(s.y-s.radius)))
+		y1 := min(h-1, int(math.Ceil(s.y+s.radius)))
+
+		for py := y0; py <= y1; py++ {
+			dy := float64(py) + 0.5 - s.y
+			for px := x0; px <= x1; px++ {
+				idx := py*w + px
+				if trans[idx] < minTransmittance {
+					continue
+				}
+				dx := float64(px) + 0.5 - s.x
+				power := -0.5*(s.conic[0]*dx*dx+s.conic[2]*dy*dy) - s.conic[1]*dx*dy
+				if power > 0 {
+					continue
+				}
+				alpha := math.Min(maxAlpha, s.alpha*math.Exp(power))
+				if alpha < minAlpha {
+					continue
+				}
+				for c := range 3 {
+					accum[idx][c] += trans[idx] * alpha * s.color[c]
+				}
+				trans[idx] *= 1 - alpha
+			}
+		}
+	}
+
+	bg := [3]float64{float64(opts.Background.R) / 255, float64(opts.Background.G) / 255, float64(opts.Background.B) / 255}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for py := range h {
+		for px := range w {
+			idx := py*w + px
+			var rgb [3]uint8
+			for c := range 3 {
+				v := accum[idx][c] + trans[idx]*bg[c]
+				rgb[c] = uint8(math.Round(math.Max(0, math.Min(1, v)) * 255))
+			}
+			img.SetRGBA(px, py, color.RGBA{R: rgb[0], G: rgb[1], B: rgb[2], A: 255})
+		}
+	}
+	return img, nil
+}
+
+// project transforms the splats to screen space and culls those behind the
+// near plane or outside the image
+func project(ctx context.Context, data *spz.SpzData, cam Camera) ([]projected, error) {
+	view, focal := cam.view()
+	cx, cy := float64(cam.Width)/2, float64(cam.Height)/2
+
+	out := make([]projected, 0, len(data.Data))
+	for i, d := range data.Data {
+		if err := cancelcheck.Check(ctx, i); err != nil {
+			return nil, err
+		}
+		world := [3]float64{float64(d.PositionX), float64(d.PositionY), float64(d.PositionZ)}
+		rel := sub(world, cam.Position)
+		t := [3]float64{dot(view[0], rel), dot(view[1], rel), dot(view[2], rel)}
+		if t[2] < cam.Near {
+			continue
+		}
+
+		// Covariance in camera space: W·Σ·Wᵀ
+		cov := spz.SplatCovariance(d)
+		var vc [3][3]float64
+		for i := range 3 {
+			for j := range 3 {
+				sum := 0.0
+				for k := range 3 {
+					for l := range 3 {
+						sum += view[i][k] * cov[k][l] * view[j][l]
+					}
+				}
+				vc[i][j] = sum
+			}
+		}
+
+		// Jacobian of the perspective projection (image y points down)
+		z2 := t[2] * t[2]
+		jac := [2][3]float64{
+			{focal / t[2], 0, -focal * t[0] / z2},
+			{0, -focal / t[2], focal * t[1] / z2},
+		}
+		var c2 [2][2]float64
+		for i := range 2 {
+			for j := range 2 {
+				sum := 0.0
+				for k := range 3 {
+					for l := range 3 {
+						sum += jac[i][k] * vc[k][l] * jac[j][l]
+					}
+				}
+				c2[i][j] = sum
+			}
+		}
+		a, b, c := c2[0][0]+lowPassVariance, c2[0][1], c2[1][1]+lowPassVariance
+		det := a*c - b*b
+		if det <= 0 {
+			continue
+		}
+
+		mid := (a + c) / 2
+		lambda := mid + math.Sqrt(math.Max(0.1, mid*mid-det))
+		radius := math.Ceil(3 * math.Sqrt(lambda))
+
+		x := cx + focal*t[0]/t[2]
+		y := cy - focal*t[1]/t[2]
+		if x+radius < 0 || x-radius > float64(cam.Width) || y+radius < 0 || y-radius > float64(cam.Height) {
+			continue
+		}
+
+		dir := sub(world, cam.Position)
+		out = append(out, projected{
+			x:      x,
+			y:      y,
+			depth:  t[2],
+			conic:  [3]float64{c / det, -b / det, a / det},
+			radius: radius,
+			color:  splatColor(d, dir),
+			alpha:  float64(d.ColorA) / 255,
+		})
+	}
+	return out, nil
+}
+
+// splatColor evaluates the view-dependent color of a splat for a view direction
+func splatColor(d *spz.SplatData, dir [3]float64) [3]float64 {
+	r, g, b := spz.EvalSH(d, [3]float32{float32(dir[0]), float32(dir[1]), float32(dir[2])})
+	return [3]float64{float64(r), float64(g), float64(b)}
+}
@@ -0,0 +1,97 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"math"
+	"testing"
+
+	spz "github.com/flywave/go-spz"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRenderTestData() *spz.SpzData {
+	data := &spz.SpzData{
+		Magic:          spz.SPZ_MAGIC,
+		Version:        3,
+		ShDegree:       1,
+		FractionalBits: 12,
+	}
+	for i := range 8 {
+		for j := range 8 {
+			data.Data = append(data.Data, &spz.SplatData{
+				PositionX: float32(i) - 3.5,
+				PositionY: float32(j) - 3.5,
+				PositionZ: float32((i+j)%3) * 0.5,
+				ScaleX:    -1.2,
+				ScaleY:    -1.5,
+				ScaleZ:    -2,
+				RotationW: 230, RotationX: 140, RotationY: 128, RotationZ: 150,
+				ColorR: uint8(i * 32), ColorG: uint8(j * 32), ColorB: 160, ColorA: 220,
+				SH1: []byte{160, 128, 96, 128, 144, 128, 112, 128, 128},
+			})
+		}
+	}
+	data.NumPoints = uint32(len(data.Data))
+	return data
+}
+
+func psnr(a, b *image.RGBA) float64 {
+	mse := 0.0
+	for i := range a.Pix {
+		d := float64(a.Pix[i]) - float64(b.Pix[i])
+		mse += d * d
+	}
+	mse /= float64(len(a.Pix))
+	if mse == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/mse)
+}
+
+// TestRender tests that a cloud renders into a non-empty PNG-encodable image
+func TestRender(t *testing.T) {
+	data := newRenderTestData()
+	cam := FitCamera(data, 96, 64)
+	img := Render(data, cam, nil)
+	assert.Equal(t, image.Rect(0, 0, 96, 64), img.Bounds())
+
+	// The center of the image is covered by splats, the corner is background
+	center := img.RGBAAt(48, 32)
+	assert.NotEqual(t, uint8(0), center.R|center.G|center.B)
+	assert.Equal(t, uint8(0), img.RGBAAt(0, 0).R)
+
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, img))
+}
+
+// TestRenderContext tests that rendering stops once the context is canceled
+func TestRenderContext(t *testing.T) {
+	data := newRenderTestData()
+	cam := FitCamera(data, 32, 32)
+	img, err := RenderContext(context.Background(), data, cam, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, Render(data, cam, nil), img)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = RenderContext(ctx, data, cam, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// TestRenderRoundTrip renders a cloud before and after an SPZ round trip as a
+// visual regression check of encode/decode fidelity
+func TestRenderRoundTrip(t *testing.T) {
+	data := newRenderTestData()
+	bts, err := spz.Encode(data)
+	assert.NoError(t, err)
+	decoded, err := spz.Decode(bts)
+	assert.NoError(t, err)
+
+	cam := FitCamera(data, 128, 128)
+	original := Render(data, cam, nil)
+	roundTrip := Render(decoded, cam, nil)
+	assert.Greater(t, psnr(original, roundTrip), 30.0)
+}
@@ -0,0 +1,118 @@
+package spz
+
+import (
+	"encoding/json"
+	"math"
+)
+
+const (
+	// minEncodedScale and maxEncodedScale bound the log scales spzEncodeScale can represent
+	minEncodedScale = -10.0
+	maxEncodedScale = 255.0/16.0 - 10.0
+)
+
+// ErrorStats summarizes absolute errors of one attribute
+type ErrorStats struct {
+	Max   float64 `json:"max"`
+	Mean  float64 `json:"mean"`
+	RMS   float64 `json:"rms"`
+	Count int     `json:"count"`
+
+	sum   float64
+	sumSq float64
+}
+
+func (s *ErrorStats) add(v float64) {
+	v = math.Abs(v)
+	s.Max = math.Max(s.Max, v)
+	s.sum += v
+	s.sumSq += v * v
+	s.Count++
+}
+
+func (s *ErrorStats) finish() {
+	if s.Count == 0 {
+		return
+	}
+	s.Mean = s.sum / float64(s.Count)
+	s.RMS = math.Sqrt(s.sumSq / float64(s.Count))
+}
+
+// RoundTripError reports the precision lost by encoding a cloud to SPZ
+type RoundTripError struct {
+	NumPoints int `json:"num_points"`
+
+	// Position is the per-axis error in scene units
+	Position ErrorStats `json:"position"`
+	// Scale is the per-axis error of the logarithmic scales
+	Scale ErrorStats `json:"scale"`
+	// Rotation is the angle between the quaternions in degrees
+	Rotation ErrorStats `json:"rotation_degrees"`
+	// Opacity and Color are errors of the 8-bit values
+	Opacity ErrorStats `json:"opacity"`
+	Color   ErrorStats `json:"color"`
+	// SH is the error of the decoded SH coefficients
+	SH ErrorStats `json:"sh"`
+
+	// ClampedScales counts splats with a scale outside the encodable range
+	ClampedScales int `json:"clamped_scales"`
+	// PositionOverflows counts splats with a coordinate outside the 24-bit fixed-point range
+	PositionOverflows int `json:"position_overflows"`
+}
+
+// ToJSON serializes the error report to indented JSON
+func (r *RoundTripError) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// MeasureRoundTripError compares a cloud with its SPZ round trip, splat by
+// splat, and reports max/mean/RMS errors per attribute
+func MeasureRoundTripError(original, roundTripped *SpzData) (*RoundTripError, error) {
+	if len(original.Data) != len(roundTripped.Data) {
+		return nil, &SpzError{"Round trip mismatch: different number of points"}
+	}
+
+	shDegree := min(original.ShDegree, roundTripped.ShDegree, 3)
+
+	report := &RoundTripError{NumPoints: len(original.Data)}
+	for i, a := range original.Data {
+		b := roundTripped.Data[i]
+
+		overflow, clamped := false, false
+		pa := [3]float32{a.PositionX, a.PositionY, a.PositionZ}
+		pb := [3]float32{b.PositionX, b.PositionY, b.PositionZ}
+		sa := [3]float32{a.ScaleX, a.ScaleY, a.ScaleZ}
+		sb := [3]float32{b.ScaleX, b.ScaleY, b.ScaleZ}
+		for c := range 3 {
+			report.Position.add(float64(pa[c]) - float64(pb[c]))
+			report.Scale.add(float64(sa[c]) - float64(sb[c]))
+			overflow = overflow || positionOverflows(float64(pa[c]))
+			clamped = clamped || float64(sa[c]) > maxEncodedScale || float64(sa[c]) < minEncodedScale
+		}
+		if overflow {
+			report.PositionOverflows++
+		}
+		if clamped {
+			report.ClampedScales++
+		}
+
+		report.Rotation.add(rotationAngleDegrees(a, b))
+
+		report.Opacity.add(float64(a.ColorA) - float64(b.ColorA))
+		report.Color.add(float64(a.ColorR) - float64(b.ColorR))
+		report.Color.add(float64(a.ColorG) - float64(b.ColorG))
+		report.Color.add(float64(a.ColorB) - float64(b.ColorB))
+
+		if shDegree > 0 {
+			sha, shb := splatSHBytes(a, shDegree), splatSHBytes(b, shDegree)
+			for k := range sha {
+				report.SH.add(decodeSHByte(sha[k]) - decodeSHByte(shb[k]))
+			}
+		}
+	}
+
+	for _, s := range []*ErrorStats{&report.Position, &report.Scale, &report.Rotation, &report.Opacity, &report.Color, &report.SH} {
+		s.finish()
+	}
+	return report, nil
+}
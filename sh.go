@@ -0,0 +1,242 @@
+package spz
+
+import (
+	"context"
+	"math"
+	"strconv"
+
+	"github.com/flywave/go-spz/internal/cancelcheck"
+)
+
+// shCoeffsForDegree is the number of SH coefficients per channel, excluding the DC term
+var shCoeffsForDegree = [4]int{0, 3, 8, 15}
+
+// shBandStart is the first coefficient index of each band (band 1 starts at 0)
+var shBandStart = [5]int{0, 0, 3, 8, 15}
+
+// splatSHBytes returns the quantized SH bytes of a splat up to the given
+// degree, coefficient-major with RGB interleaved. Bands that are not present
+// on the splat are padded with the zero coefficient. Band 1 comes from SH1
+// at degree 1 and from SH2 above, each falling back to the other.
+func splatSHBytes(d *SplatData, degree uint8) []byte {
+	size := shCoeffsForDegree[min(int(degree), 3)] * 3
+	out := make([]byte, size)
+	for i := range out {
+		out[i] = encodeSplatSH(0.0)
+	}
+
+	src := d.SH2
+	if len(src) == 0 || degree == 1 && len(d.SH1) > 0 {
+		src = d.SH1
+	}
+	copy(out, src[:min(len(src), 24)])
+	// SH3 always starts after the 24 band 1 and 2 coefficients, which are
+	// left zero if SH2 is missing
+	if size > 24 {
+		copy(out[24:], d.SH3)
+	}
+	return out
+}
+
+// decodeSHByte converts a quantized SH byte to its coefficient value
+func decodeSHByte(b uint8) float64 {
+	return (float64(b) - 128.0) / 128.0
+}
+
+// Real spherical harmonics basis constants, as used by 3D Gaussian Splatting
+const SH_C1 = 0.4886025119029199
+
+var (
+	shC2 = [5]float64{1.0925484305920792, -1.0925484305920792, 0.31539156525252005, -1.0925484305920792, 0.5462742152960396}
+	shC3 = [7]float64{-0.5900435899266435, 2.890611442640554, -0.4570457994644658, 0.3731763325901154, -0.4570457994644658, 1.445305721320277, -0.5900435899266435}
+)
+
+// SplatSHDegree returns the SH degree carried by the splat's SH slices: the
+// highest band present. Lower bands that are missing count as zero, as in
+// splatSHBytes.
+func SplatSHDegree(d *SplatData) uint8 {
+	switch {
+	case len(d.SH3) > 0:
+		return 3
+	case len(d.SH2) > 0:
+		return 2
+	case len(d.SH1) > 0:
+		return 1
+	}
+	return 0
+}
+
+// DecodeSH decodes the quantized SH bytes of a splat into float coefficients
+// up to the given degree. The result holds 3 values (RGB) per coefficient,
+// ordered by coefficient; missing bands decode as zero.
+func DecodeSH(d *SplatData, degree uint8) []float32 {
+	sh := splatSHBytes(d, degree)
+	out := make([]float32, len(sh))
+	for i, b := range sh {
+		out[i] = float32(decodeSHByte(b))
+	}
+	return out
+}
+
+// shBasis evaluates the real SH basis (without the DC term) for a unit
+// direction into out, which must hold 15 values
+func shBasis(dir [3]float64, out []float64) {
+	x, y, z := dir[0], dir[1], dir[2]
+	xx, yy, zz := x*x, y*y, z*z
+
+	out[0] = -SH_C1 * y
+	out[1] = SH_C1 * z
+	out[2] = -SH_C1 * x
+
+	out[3] = shC2[0] * x * y
+	out[4] = shC2[1] * y * z
+	out[5] = shC2[2] * (2*zz - xx - yy)
+	out[6] = shC2[3] * x * z
+	out[7] = shC2[4] * (xx - yy)
+
+	out[8] = shC3[0] * y * (3*xx - yy)
+	out[9] = shC3[1] * x * y * z
+	out[10] = shC3[2] * y * (4*zz - xx - yy)
+	out[11] = shC3[3] * z * (2*zz - 3*xx - 3*yy)
+	out[12] = shC3[4] * x * (4*zz - xx - yy)
+	out[13] = shC3[5] * z * (xx - yy)
+	out[14] = shC3[6] * x * (xx - 3*yy)
+}
+
+// normalizeDir returns the unit vector of dir, or +Z for a zero vector
+func normalizeDir(dir [3]float32) [3]float64 {
+	v := [3]float64{float64(dir[0]), float64(dir[1]), float64(dir[2])}
+	l := math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+	if l == 0 {
+		return [3]float64{0, 0, 1}
+	}
+	return [3]float64{v[0] / l, v[1] / l, v[2] / l}
+}
+
+// evalSH evaluates the color of a splat for a unit direction, with SH
+// truncated to the given degree
+func evalSH(d *SplatData, degree uint8, dir [3]float64, basis []float64) [3]float64 {
+	rgb := [3]float64{float64(d.ColorR) / 255, float64(d.ColorG) / 255, float64(d.ColorB) / 255}
+	if degree == 0 {
+		return rgb
+	}
+
+	sh := splatSHBytes(d, degree)
+	shBasis(dir, basis)
+	for k := range shCoeffsForDegree[degree] {
+		for c := range 3 {
+			rgb[c] += basis[k] * decodeSHByte(sh[k*3+c])
+		}
+	}
+	return rgb
+}
+
+// EvalSH evaluates the view-dependent color of a splat seen along dir (from
+// the viewer towards the splat). The DC color plus the SH bands carried by the
+// splat are summed in [0, 1] color units; negative results are clamped to 0.
+func EvalSH(d *SplatData, dir [3]float32) (r, g, b float32) {
+	var basis [15]float64
+	rgb := evalSH(d, SplatSHDegree(d), normalizeDir(dir), basis[:])
+	return float32(max(0, rgb[0])), float32(max(0, rgb[1])), float32(max(0, rgb[2]))
+}
+
+// EvalSHBatch evaluates the view-dependent color of every splat. dirs holds
+// 3 floats per splat, or a single direction shared by all splats. The result
+// holds 3 floats (RGB) per splat.
+func EvalSHBatch(data *SpzData, dirs []float32) ([]float32, error) {
+	n := len(data.Data)
+	if len(dirs) != 3 && len(dirs) != 3*n {
+		return nil, &SpzError{"Invalid direction buffer: expected 3 floats per splat"}
+	}
+
+	out := make([]float32, 3*n)
+	for i, d := range data.Data {
+		off := 0
+		if len(dirs) != 3 {
+			off = i * 3
+		}
+		out[i*3], out[i*3+1], out[i*3+2] = EvalSH(d, [3]float32(dirs[off:off+3]))
+	}
+	return out, nil
+}
+
+// setSplatSH stores packed SH bytes on a splat using the slice layout that
+// readSpzDatas produces for the given degree
+func setSplatSH(d *SplatData, degree uint8, sh []byte) {
+	d.SH1, d.SH2, d.SH3 = nil, nil, nil
+	switch degree {
+	case 1:
+		d.SH1 = sh[:9]
+	case 2:
+		d.SH2 = sh[:24]
+	case 3:
+		d.SH2 = sh[:24:24]
+		d.SH3 = sh[24:45]
+	}
+}
+
+// ChangeSHDegree truncates or pads the SH bands of every splat to newDegree
+// and rewrites ShDegree in the header. Promoted bands are padded with exact
+// zero coefficients. When preserveBrightness is set, the energy of the
+// dropped bands is folded into the DC color per channel, which only ever
+// brightens the channel.
+func ChangeSHDegree(data *SpzData, newDegree uint8, preserveBrightness bool) error {
+	return ChangeSHDegreeContext(context.Background(), data, newDegree, preserveBrightness)
+}
+
+// ChangeSHDegreeContext is ChangeSHDegree with cancellation: it returns the
+// context error once ctx is canceled, leaving the cloud unchanged
+func ChangeSHDegreeContext(ctx context.Context, data *SpzData, newDegree uint8, preserveBrightness bool) error {
+	if newDegree > 3 {
+		return &SpzError{"Unsupported SH degree: " + strconv.Itoa(int(newDegree))}
+	}
+
+	// The splats are only rewritten once every one of them is converted
+	type converted struct {
+		sh      []byte
+		r, g, b uint8
+	}
+	out := make([]converted, len(data.Data))
+	for i, d := range data.Data {
+		if err := cancelcheck.Check(ctx, i); err != nil {
+			return err
+		}
+		oldDegree := SplatSHDegree(d)
+		full := splatSHBytes(d, 3)
+
+		c := &out[i]
+		c.r, c.g, c.b = d.ColorR, d.ColorG, d.ColorB
+		if preserveBrightness && newDegree < oldDegree {
+			var dropped [3]float64
+			for k := shCoeffsForDegree[newDegree]; k < shCoeffsForDegree[oldDegree]; k++ {
+				for ch := range 3 {
+					v := decodeSHByte(full[k*3+ch])
+					dropped[ch] += v * v
+				}
+			}
+			c.r = foldSHEnergy(d.ColorR, dropped[0])
+			c.g = foldSHEnergy(d.ColorG, dropped[1])
+			c.b = foldSHEnergy(d.ColorB, dropped[2])
+		}
+
+		c.sh = make([]byte, shCoeffsForDegree[newDegree]*3)
+		copy(c.sh, full)
+	}
+
+	for i, d := range data.Data {
+		d.ColorR, d.ColorG, d.ColorB = out[i].r, out[i].g, out[i].b
+		setSplatSH(d, newDegree, out[i].sh)
+	}
+	data.ShDegree = newDegree
+	return nil
+}
+
+// foldSHEnergy folds SH energy into the DC coefficient of a color channel.
+// The coefficient grows by the amount that keeps the total energy of a
+// non-negative DC term, so dark channels brighten like bright ones instead
+// of getting darker.
+func foldSHEnergy(color uint8, energy float64) uint8 {
+	dc := (float64(color)/255.0 - 0.5) / SH_C0
+	folded := dc + math.Sqrt(dc*dc+energy) - math.Abs(dc)
+	return clipUint8Round((folded*SH_C0 + 0.5) * 255.0)
+}
@@ -0,0 +1,157 @@
+package spz
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEvalSH tests SH decoding and evaluation against the real SH basis
+func TestEvalSH(t *testing.T) {
+	// First band-1 coefficient (the -y basis) is 0.5 on red only
+	d := &SplatData{ColorR: 128, ColorG: 64, ColorB: 32, SH1: []byte{192, 128, 128, 128, 128, 128, 128, 128, 128}}
+	assert.Equal(t, uint8(1), SplatSHDegree(d))
+
+	coeffs := DecodeSH(d, 2)
+	assert.Len(t, coeffs, 24)
+	assert.InDelta(t, 0.5, coeffs[0], 1e-6)
+	assert.InDelta(t, 0.0, coeffs[23], 1e-6)
+
+	r, g, b := EvalSH(d, [3]float32{0, -2, 0})
+	assert.InDelta(t, 128.0/255+0.5*SH_C1, r, 1e-6)
+	assert.InDelta(t, 64.0/255, g, 1e-6)
+	assert.InDelta(t, 32.0/255, b, 1e-6)
+
+	// Perpendicular to the lobe only the DC color remains
+	r, _, _ = EvalSH(d, [3]float32{1, 0, 0})
+	assert.InDelta(t, 128.0/255, r, 1e-6)
+
+	data := &SpzData{ShDegree: 1, Data: []*SplatData{d, d}}
+	colors, err := EvalSHBatch(data, []float32{0, -1, 0})
+	assert.NoError(t, err)
+	assert.Len(t, colors, 6)
+	assert.InDelta(t, 128.0/255+0.5*SH_C1, colors[3], 1e-6)
+
+	colors, err = EvalSHBatch(data, []float32{0, -1, 0, 1, 0, 0})
+	assert.NoError(t, err)
+	assert.InDelta(t, 128.0/255, colors[3], 1e-6)
+
+	_, err = EvalSHBatch(data, []float32{0, 1})
+	assert.Error(t, err)
+
+	// SH3 without the lower bands is evaluated as degree 3 with zero lower
+	// bands, as it is serialized; out[10] is the 4zz-xx-yy lobe along y
+	sh3 := make([]byte, 21)
+	for i := range sh3 {
+		sh3[i] = 128
+	}
+	sh3[6] = 192
+	d = &SplatData{ColorR: 128, SH3: sh3}
+	assert.Equal(t, uint8(3), SplatSHDegree(d))
+	assert.Equal(t, byte(192), splatSHBytes(d, 3)[30])
+	r, _, _ = EvalSH(d, [3]float32{0, 1, 0})
+	assert.InDelta(t, 128.0/255-0.5*shC3[2], r, 1e-6)
+
+	// Band 1 comes from SH1 at degree 1 and from SH2 above
+	sh2 := make([]byte, 24)
+	for i := range sh2 {
+		sh2[i] = 64
+	}
+	d = &SplatData{SH1: []byte{192, 192, 192, 192, 192, 192, 192, 192, 192}, SH2: sh2}
+	assert.Equal(t, d.SH1, splatSHBytes(d, 1))
+	assert.Equal(t, sh2, splatSHBytes(d, 2))
+}
+
+// TestChangeSHDegree tests SH band truncation, promotion and energy folding
+func TestChangeSHDegree(t *testing.T) {
+	sh2 := make([]byte, 24)
+	sh3 := make([]byte, 21)
+	for i := range sh2 {
+		sh2[i] = 128
+	}
+	for i := range sh3 {
+		sh3[i] = 192
+	}
+	sh2[0] = 64
+	data := &SpzData{ShDegree: 3, Data: []*SplatData{
+		{ColorR: 200, ColorG: 100, ColorB: 128, SH2: sh2, SH3: sh3},
+		{ColorR: 10, ColorG: 20, ColorB: 30, SH3: sh3},
+	}}
+
+	assert.NoError(t, ChangeSHDegree(data, 1, false))
+	assert.Equal(t, uint8(1), data.ShDegree)
+	assert.Equal(t, []byte{64, 128, 128, 128, 128, 128, 128, 128, 128}, data.Data[0].SH1)
+	assert.Nil(t, data.Data[0].SH2)
+	assert.Nil(t, data.Data[0].SH3)
+	assert.Equal(t, uint8(200), data.Data[0].ColorR)
+
+	// Promotion pads with the exact zero coefficient
+	assert.NoError(t, ChangeSHDegree(data, 3, false))
+	assert.Len(t, data.Data[0].SH2, 24)
+	assert.Len(t, data.Data[0].SH3, 21)
+	assert.Equal(t, byte(64), data.Data[0].SH2[0])
+	assert.Equal(t, byte(128), data.Data[0].SH3[20])
+
+	// Folding brightens each channel by the energy of the dropped band: 21
+	// coefficients of 0.5 give 7 * 0.25 per channel
+	data.Data[0].SH3 = sh3
+	data.Data[1].SH2, data.Data[1].SH3 = nil, sh3
+	assert.NoError(t, ChangeSHDegree(data, 2, true))
+	assert.Equal(t, foldSHEnergy(200, 1.75), data.Data[0].ColorR)
+	assert.Greater(t, data.Data[0].ColorR, uint8(200))
+	assert.Equal(t, uint8(2), SplatSHDegree(data.Data[0]))
+
+	// A splat with only SH3 folds it into its dark colors, which brighten
+	assert.Equal(t, []uint8{foldSHEnergy(10, 1.75), foldSHEnergy(20, 1.75), foldSHEnergy(30, 1.75)},
+		[]uint8{data.Data[1].ColorR, data.Data[1].ColorG, data.Data[1].ColorB})
+	assert.Greater(t, data.Data[1].ColorR, uint8(10))
+	assert.Nil(t, data.Data[1].SH3)
+
+	assert.Error(t, ChangeSHDegree(data, 4, false))
+}
+
+// TestFoldSHEnergy tests that folding brightens dark and bright channels
+func TestFoldSHEnergy(t *testing.T) {
+	assert.Equal(t, uint8(82), foldSHEnergy(64, 0.5))
+	assert.Equal(t, uint8(216), foldSHEnergy(200, 0.5))
+	assert.Equal(t, uint8(64), foldSHEnergy(64, 0))
+	assert.Equal(t, uint8(255), foldSHEnergy(255, 0.5))
+}
+
+// TestBakeSH tests averaging view-dependent color into a degree-0 copy
+func TestBakeSH(t *testing.T) {
+	d := &SplatData{PositionZ: 5, ColorR: 128, ColorG: 128, ColorB: 128, SH1: []byte{128, 128, 128, 160, 128, 128, 128, 128, 128}}
+	data := &SpzData{Magic: SPZ_MAGIC, Version: 3, ShDegree: 1, NumPoints: 1, FractionalBits: 12, Data: []*SplatData{d}}
+
+	// Band 1 averages to zero over the sphere
+	baked, err := BakeSH(data, ViewSet{Mode: ViewsSphere, Samples: 256})
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(0), baked.ShDegree)
+	assert.Nil(t, baked.Data[0].SH1)
+	assert.InDelta(t, 128, int(baked.Data[0].ColorR), 1)
+	assert.NotNil(t, d.SH1, "input must not be modified")
+
+	// Seen from the origin the view direction is +Z, which brightens red
+	baked, err = BakeSH(data, ViewSet{Mode: ViewsCameras, Cameras: [][3]float32{{0, 0, 0}}})
+	assert.NoError(t, err)
+	assert.InDelta(t, 128+0.25*SH_C1*255, int(baked.Data[0].ColorR), 1)
+	assert.Equal(t, uint8(128), baked.Data[0].ColorG)
+
+	// Baked colors survive writing and reading unchanged
+	decoded, err := Decode(mustEncode(t, baked, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, baked.Data[0].ColorR, decoded.Data[0].ColorR)
+	assert.Equal(t, baked.Data[0].ColorG, decoded.Data[0].ColorG)
+	for v := range 256 {
+		c := bakedColor(float64(v) / 255)
+		assert.Equal(t, c, spzDecodeColor(spzEncodeColor(c)), "color %d", v)
+	}
+
+	// Viewers above look down -Z at the splat, darkening red
+	baked, err = BakeSH(data, ViewSet{Mode: ViewsHemisphere, Up: [3]float32{0, 0, 1}})
+	assert.NoError(t, err)
+	assert.Less(t, baked.Data[0].ColorR, uint8(128))
+
+	_, err = BakeSH(data, ViewSet{Mode: ViewsCameras})
+	assert.Error(t, err)
+}
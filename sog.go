@@ -0,0 +1,302 @@
+package spz
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"image"
+	"image/color"
+	_ "image/png"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// sogShNEntriesPerRow is the number of SH palette entries per centroid texture row
+const sogShNEntriesPerRow = 64
+
+// sogRange holds SOG min/max values, which are arrays or, for shN in
+// version 1, a single number
+type sogRange []float64
+
+func (r *sogRange) UnmarshalJSON(b []byte) error {
+	var v float64
+	if err := json.Unmarshal(b, &v); err == nil {
+		*r = sogRange{v}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]float64)(r))
+}
+
+func (r sogRange) at(i int) float64 {
+	if len(r) == 0 {
+		return 0
+	}
+	return r[min(i, len(r)-1)]
+}
+
+type sogAttribute struct {
+	Shape    []int     `json:"shape"`
+	Mins     sogRange  `json:"mins"`
+	Maxs     sogRange  `json:"maxs"`
+	Codebook []float64 `json:"codebook"`
+	Count    int       `json:"count"`
+	Bands    int       `json:"bands"`
+	Files    []string  `json:"files"`
+}
+
+// sogMeta is the meta.json of a PlayCanvas SOG scene
+type sogMeta struct {
+	Version int           `json:"version"`
+	Count   int           `json:"count"`
+	Means   sogAttribute  `json:"means"`
+	Scales  sogAttribute  `json:"scales"`
+	Quats   sogAttribute  `json:"quats"`
+	Sh0     sogAttribute  `json:"sh0"`
+	ShN     *sogAttribute `json:"shN"`
+}
+
+// sogTexture is a decoded SOG texture with splat i at pixel (i % width, i / width)
+type sogTexture struct {
+	img   image.Image
+	width int
+}
+
+func (t *sogTexture) pixel(i int) [4]uint8 {
+	x, y := i%t.width, i/t.width
+	if nrgba, ok := t.img.(*image.NRGBA); ok {
+		o := nrgba.PixOffset(nrgba.Rect.Min.X+x, nrgba.Rect.Min.Y+y)
+		return [4]uint8(nrgba.Pix[o : o+4])
+	}
+	c := color.NRGBAModel.Convert(t.img.At(t.img.Bounds().Min.X+x, t.img.Bounds().Min.Y+y)).(color.NRGBA)
+	return [4]uint8{c.R, c.G, c.B, c.A}
+}
+
+// ReadSog reads a PlayCanvas SOG scene from a .sog bundle (a zip archive) or
+// from a meta.json file with its textures in the same directory. PNG
+// textures are supported out of the box; lossless WebP textures require a
+// WebP decoder registered with the image package, for example by importing
+// golang.org/x/image/webp. Coordinates are kept as stored in the source.
+func ReadSog(file string) (*SpzData, error) {
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		return DecodeSog(os.DirFS(filepath.Dir(file)), filepath.Base(file))
+	}
+
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return DecodeSog(zr, "meta.json")
+}
+
+// DecodeSog decodes a SOG scene from a file system holding the meta.json
+// named metaName and the textures it references
+func DecodeSog(fsys fs.FS, metaName string) (*SpzData, error) {
+	metaBytes, err := fs.ReadFile(fsys, metaName)
+	if err != nil {
+		return nil, err
+	}
+	var meta sogMeta
+	if err := json.Unmarshal(metaBytes, &meta); err != nil {
+		return nil, &SpzError{"Invalid SOG meta: " + err.Error()}
+	}
+
+	count := meta.Count
+	if meta.Version < 2 && len(meta.Means.Shape) > 0 {
+		count = meta.Means.Shape[0]
+	}
+	if count < 0 {
+		return nil, &SpzError{"Invalid SOG meta: negative splat count"}
+	}
+
+	dir := path.Dir(metaName)
+	load := func(attr *sogAttribute, n int) ([]*sogTexture, error) {
+		if len(attr.Files) < n {
+			return nil, &SpzError{"Invalid SOG meta: missing texture files"}
+		}
+		textures := make([]*sogTexture, n)
+		for i := range n {
+			f, err := fsys.Open(path.Join(dir, attr.Files[i]))
+			if err != nil {
+				return nil, err
+			}
+			img, _, err := image.Decode(f)
+			f.Close()
+			if err != nil {
+				return nil, &SpzError{"Invalid SOG texture " + attr.Files[i] + ": " + err.Error()}
+			}
+			textures[i] = &sogTexture{img: img, width: img.Bounds().Dx()}
+		}
+		return textures, nil
+	}
+	checkSize := func(textures []*sogTexture) error {
+		for _, t := range textures {
+			if t.width == 0 || t.width*t.img.Bounds().Dy() < count {
+				return &SpzError{"Invalid SOG texture: too small for splat count"}
+			}
+		}
+		return nil
+	}
+
+	means, err := load(&meta.Means, 2)
+	if err != nil {
+		return nil, err
+	}
+	scales, err := load(&meta.Scales, 1)
+	if err != nil {
+		return nil, err
+	}
+	quats, err := load(&meta.Quats, 1)
+	if err != nil {
+		return nil, err
+	}
+	sh0, err := load(&meta.Sh0, 1)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range [][]*sogTexture{means, scales, quats, sh0} {
+		if err := checkSize(t); err != nil {
+			return nil, err
+		}
+	}
+
+	var shDegree uint8
+	var shN []*sogTexture
+	coeffs := 0
+	if meta.ShN != nil {
+		if shN, err = load(meta.ShN, 2); err != nil {
+			return nil, err
+		}
+		if err := checkSize(shN[1:]); err != nil {
+			return nil, err
+		}
+		if meta.ShN.Bands > 0 {
+			coeffs = shCoeffsForDegree[max(0, min(meta.ShN.Bands, 3))]
+		} else {
+			coeffs = shN[0].width / sogShNEntriesPerRow
+		}
+		for deg := 3; deg > 0; deg-- {
+			if coeffs == shCoeffsForDegree[deg] {
+				shDegree = uint8(deg)
+			}
+		}
+		if shDegree == 0 {
+			return nil, &SpzError{"Invalid SOG meta: unsupported SH band count"}
+		}
+		if meta.Version >= 2 && len(meta.ShN.Codebook) < 256 {
+			return nil, &SpzError{"Invalid SOG meta: shN codebook must have 256 entries"}
+		}
+	}
+	if meta.Version >= 2 && (len(meta.Scales.Codebook) < 256 || len(meta.Sh0.Codebook) < 256) {
+		return nil, &SpzError{"Invalid SOG meta: codebooks must have 256 entries"}
+	}
+
+	spzData := &SpzData{
+		Magic:          SPZ_MAGIC,
+		Version:        3,
+		NumPoints:      uint32(count),
+		ShDegree:       shDegree,
+		FractionalBits: 12,
+		Data:           make([]*SplatData, count),
+	}
+
+	for i := range count {
+		d := &SplatData{}
+
+		// Means are 16-bit, split into low and high byte textures, and log-encoded
+		lo, hi := means[0].pixel(i), means[1].pixel(i)
+		var pos [3]float32
+		for c := range 3 {
+			v := float64(uint16(hi[c])<<8|uint16(lo[c])) / 65535
+			v = lerp(meta.Means.Mins.at(c), meta.Means.Maxs.at(c), v)
+			pos[c] = float32(math.Copysign(math.Exp(math.Abs(v))-1, v))
+		}
+		d.PositionX, d.PositionY, d.PositionZ = pos[0], pos[1], pos[2]
+
+		s := scales[0].pixel(i)
+		var scale [3]float32
+		for c := range 3 {
+			if meta.Version >= 2 {
+				scale[c] = float32(meta.Scales.Codebook[s[c]])
+			} else {
+				scale[c] = float32(lerp(meta.Scales.Mins.at(c), meta.Scales.Maxs.at(c), float64(s[c])/255))
+			}
+		}
+		d.ScaleX, d.ScaleY, d.ScaleZ = scale[0], scale[1], scale[2]
+
+		setSplatRotation(d, unpackSogQuat(quats[0].pixel(i)))
+
+		c0 := sh0[0].pixel(i)
+		var dc [3]float64
+		for c := range 3 {
+			if meta.Version >= 2 {
+				dc[c] = meta.Sh0.Codebook[c0[c]]
+			} else {
+				dc[c] = lerp(meta.Sh0.Mins.at(c), meta.Sh0.Maxs.at(c), float64(c0[c])/255)
+			}
+		}
+		d.ColorR = clipUint8Round((dc[0]*SH_C0 + 0.5) * 255)
+		d.ColorG = clipUint8Round((dc[1]*SH_C0 + 0.5) * 255)
+		d.ColorB = clipUint8Round((dc[2]*SH_C0 + 0.5) * 255)
+		if meta.Version >= 2 {
+			d.ColorA = c0[3]
+		} else {
+			// Version 1 stores the opacity logit
+			logit := lerp(meta.Sh0.Mins.at(3), meta.Sh0.Maxs.at(3), float64(c0[3])/255)
+			d.ColorA = clipUint8Round(255 / (1 + math.Exp(-logit)))
+		}
+
+		if shDegree > 0 {
+			label := shN[1].pixel(i)
+			idx := int(label[0]) | int(label[1])<<8
+			row, col := idx/sogShNEntriesPerRow, (idx%sogShNEntriesPerRow)*coeffs
+			if row >= shN[0].img.Bounds().Dy() || col+coeffs > shN[0].width {
+				return nil, &SpzError{"Invalid SOG data: shN label out of range"}
+			}
+			base := row*shN[0].width + col
+			sh := make([]byte, coeffs*3)
+			for k := range coeffs {
+				p := shN[0].pixel(base + k)
+				for c := range 3 {
+					var v float64
+					if meta.Version >= 2 {
+						v = meta.ShN.Codebook[p[c]]
+					} else {
+						v = lerp(meta.ShN.Mins.at(0), meta.ShN.Maxs.at(0), float64(p[c])/255)
+					}
+					sh[k*3+c] = encodeSplatSH(v)
+				}
+			}
+			setSplatSH(d, shDegree, sh)
+		}
+
+		spzData.Data[i] = d
+	}
+
+	return spzData, nil
+}
+
+// unpackSogQuat unpacks a SOG quaternion: three components in RGB and
+// 252 plus the index of the omitted largest component in alpha, returning
+// (w, x, y, z)
+func unpackSogQuat(p [4]uint8) [4]float64 {
+	a := (float64(p[0])/255 - 0.5) * math.Sqrt2
+	b := (float64(p[1])/255 - 0.5) * math.Sqrt2
+	c := (float64(p[2])/255 - 0.5) * math.Sqrt2
+	m := math.Sqrt(math.Max(0, 1-(a*a+b*b+c*c)))
+
+	switch int(p[3]) - 252 {
+	case 0:
+		return [4]float64{m, a, b, c}
+	case 1:
+		return [4]float64{a, m, b, c}
+	case 2:
+		return [4]float64{a, b, m, c}
+	default:
+		return [4]float64{a, b, c, m}
+	}
+}
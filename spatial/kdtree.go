@@ -0,0 +1,234 @@
+package spatial
+
+import (
+	"context"
+
+	spz "github.com/flywave/go-spz"
+)
+
+// KDTree is a balanced k-d tree over points. The tree is implicit: the
+// median of each index range is the node splitting it, so no node storage
+// is needed beyond a permutation and the split axes.
+type KDTree struct {
+	positions []float32
+	perm      []int32
+	axis      []uint8
+	bounds    spz.Bounds
+}
+
+var _ Index = (*KDTree)(nil)
+
+// NewKDTree builds a k-d tree over a columnar x, y, z position buffer. The
+// buffer is referenced, not copied, and must not be modified afterwards.
+func NewKDTree(positions []float32) (*KDTree, error) {
+	return NewKDTreeContext(context.Background(), positions)
+}
+
+// NewKDTreeContext is NewKDTree with cancellation: it returns the context
+// error once ctx is canceled
+func NewKDTreeContext(ctx context.Context, positions []float32) (*KDTree, error) {
+	if err := checkPositions(positions); err != nil {
+		return nil, err
+	}
+	n := len(positions) / 3
+	t := &KDTree{
+		positions: positions,
+		perm:      make([]int32, n),
+		axis:      make([]uint8, n),
+	}
+	for i := range t.perm {
+		t.perm[i] = int32(i)
+	}
+	t.bounds = boundsOf(positions, t.perm)
+
+	b := newBuilder(ctx)
+	t.build(b, 0, n)
+	if err := b.wait(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// NewKDTreeFromSpz builds a k-d tree over splat centers
+func NewKDTreeFromSpz(data *spz.SpzData) *KDTree {
+	t, _ := NewKDTree(Positions(data))
+	return t
+}
+
+// build splits the range [lo, hi) on the axis of largest extent
+func (t *KDTree) build(b *builder, lo, hi int) {
+	if hi-lo <= 1 || b.canceled(hi-lo) {
+		return
+	}
+	idx := t.perm[lo:hi]
+	bounds := boundsOf(t.positions, idx)
+	axis := 0
+	for c := 1; c < 3; c++ {
+		if bounds.Max[c]-bounds.Min[c] > bounds.Max[axis]-bounds.Min[axis] {
+			axis = c
+		}
+	}
+
+	mid := (lo + hi) / 2
+	selectNth(idx, mid-lo, func(i int32) float32 { return t.positions[3*int(i)+axis] })
+	t.axis[mid] = uint8(axis)
+
+	b.spawn(mid-lo, func() { t.build(b, lo, mid) })
+	t.build(b, mid+1, hi)
+}
+
+// selectNth reorders idx so that idx[n] holds the element with the n-th
+// smallest key, smaller or equal keys before and larger or equal keys after
+func selectNth(idx []int32, n int, key func(int32) float32) {
+	lo, hi := 0, len(idx)-1
+	for hi > lo {
+		mid := lo + (hi-lo)/2
+		if key(idx[mid]) < key(idx[lo]) {
+			idx[mid], idx[lo] = idx[lo], idx[mid]
+		}
+		if key(idx[hi]) < key(idx[lo]) {
+			idx[hi], idx[lo] = idx[lo], idx[hi]
+		}
+		if key(idx[hi]) < key(idx[mid]) {
+			idx[hi], idx[mid] = idx[mid], idx[hi]
+		}
+		pivot := key(idx[mid])
+
+		i, j := lo, hi
+		for i <= j {
+			for key(idx[i]) < pivot {
+				i++
+			}
+			for key(idx[j]) > pivot {
+				j--
+			}
+			if i <= j {
+				idx[i], idx[j] = idx[j], idx[i]
+				i++
+				j--
+			}
+		}
+
+		switch {
+		case n <= j:
+			hi = j
+		case n >= i:
+			lo = i
+		default:
+			return
+		}
+	}
+}
+
+// Len returns the number of indexed points
+func (t *KDTree) Len() int {
+	return len(t.perm)
+}
+
+// node returns the point index, position and split axis of the node of [lo, hi)
+func (t *KDTree) node(lo, hi int) (int32, [3]float32, int, int) {
+	mid := (lo + hi) / 2
+	i := t.perm[mid]
+	return i, point(t.positions, i), int(t.axis[mid]), mid
+}
+
+// KNearest returns the k points nearest to p, nearest first
+func (t *KDTree) KNearest(p [3]float32, k int) []int {
+	if k <= 0 {
+		return nil
+	}
+	h := &neighbours{k: k}
+	t.knn(0, len(t.perm), p, h)
+	return h.sorted()
+}
+
+func (t *KDTree) knn(lo, hi int, p [3]float32, h *neighbours) {
+	if lo >= hi {
+		return
+	}
+	i, q, axis, mid := t.node(lo, hi)
+	h.add(dist2(p, q), i)
+
+	d := p[axis] - q[axis]
+	nearLo, nearHi, farLo, farHi := lo, mid, mid+1, hi
+	if d > 0 {
+		nearLo, nearHi, farLo, farHi = mid+1, hi, lo, mid
+	}
+	t.knn(nearLo, nearHi, p, h)
+	if d*d < h.bound() {
+		t.knn(farLo, farHi, p, h)
+	}
+}
+
+// Radius returns the points within distance r of p, in no particular order
+func (t *KDTree) Radius(p [3]float32, r float32) []int {
+	var out []int
+	t.radius(0, len(t.perm), p, r*r, &out)
+	return out
+}
+
+func (t *KDTree) radius(lo, hi int, p [3]float32, r2 float32, out *[]int) {
+	if lo >= hi {
+		return
+	}
+	i, q, axis, mid := t.node(lo, hi)
+	if dist2(p, q) <= r2 {
+		*out = append(*out, int(i))
+	}
+	d := p[axis] - q[axis]
+	if d <= 0 || d*d <= r2 {
+		t.radius(lo, mid, p, r2, out)
+	}
+	if d >= 0 || d*d <= r2 {
+		t.radius(mid+1, hi, p, r2, out)
+	}
+}
+
+// Box returns the points inside b, bounds included, in no particular order
+func (t *KDTree) Box(b spz.Bounds) []int {
+	var out []int
+	t.box(0, len(t.perm), b, &out)
+	return out
+}
+
+func (t *KDTree) box(lo, hi int, b spz.Bounds, out *[]int) {
+	if lo >= hi {
+		return
+	}
+	i, q, axis, mid := t.node(lo, hi)
+	if inBox(q, b) {
+		*out = append(*out, int(i))
+	}
+	if b.Min[axis] <= q[axis] {
+		t.box(lo, mid, b, out)
+	}
+	if b.Max[axis] >= q[axis] {
+		t.box(mid+1, hi, b, out)
+	}
+}
+
+// Ray returns the points within distance radius of the ray from origin
+// along dir, ordered by distance along the ray
+func (t *KDTree) Ray(origin, dir [3]float32, radius float32) []int {
+	r, ok := newRay(origin, dir, radius)
+	if !ok {
+		return nil
+	}
+	hits := &rayHits{}
+	t.ray(0, len(t.perm), t.bounds, r, hits)
+	return hits.sorted()
+}
+
+func (t *KDTree) ray(lo, hi int, bounds spz.Bounds, r ray, hits *rayHits) {
+	if lo >= hi || !r.crosses(bounds) {
+		return
+	}
+	i, q, axis, mid := t.node(lo, hi)
+	if d, ok := r.hit(q); ok {
+		hits.add(d, i)
+	}
+	left, right := bounds, bounds
+	left.Max[axis], right.Min[axis] = q[axis], q[axis]
+	t.ray(lo, mid, left, r, hits)
+	t.ray(mid+1, hi, right, r, hits)
+}
@@ -0,0 +1,242 @@
+package spatial
+
+import (
+	"context"
+
+	spz "github.com/flywave/go-spz"
+)
+
+const (
+	// DefaultOctreeLeafSize is the leaf capacity used when none is given
+	DefaultOctreeLeafSize = 16
+	// maxOctreeDepth stops subdividing coincident points
+	maxOctreeDepth = 21
+)
+
+// octreeNode covers perm[start:end]; leaves have no children
+type octreeNode struct {
+	bounds     spz.Bounds
+	start, end int
+	children   []*octreeNode
+}
+
+// Octree is a point octree whose nodes are split at the center of their
+// bounding box until they hold at most leafSize points
+type Octree struct {
+	positions []float32
+	perm      []int32
+	root      *octreeNode
+	leafSize  int
+}
+
+var _ Index = (*Octree)(nil)
+
+// NewOctree builds an octree over a columnar x, y, z position buffer. A
+// leafSize of 0 selects DefaultOctreeLeafSize. The buffer is referenced, not
+// copied, and must not be modified afterwards.
+func NewOctree(positions []float32, leafSize int) (*Octree, error) {
+	return NewOctreeContext(context.Background(), positions, leafSize)
+}
+
+// NewOctreeContext is NewOctree with cancellation: it returns the context
+// error once ctx is canceled
+func NewOctreeContext(ctx context.Context, positions []float32, leafSize int) (*Octree, error) {
+	if err := checkPositions(positions); err != nil {
+		return nil, err
+	}
+	if leafSize <= 0 {
+		leafSize = DefaultOctreeLeafSize
+	}
+	n := len(positions) / 3
+	o := &Octree{positions: positions, perm: make([]int32, n), leafSize: leafSize}
+	for i := range o.perm {
+		o.perm[i] = int32(i)
+	}
+	o.root = &octreeNode{bounds: boundsOf(positions, o.perm), end: n}
+
+	b := newBuilder(ctx)
+	o.build(b, o.root, make([]int32, n), 0)
+	if err := b.wait(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// NewOctreeFromSpz builds an octree over splat centers
+func NewOctreeFromSpz(data *spz.SpzData, leafSize int) *Octree {
+	o, _ := NewOctree(Positions(data), leafSize)
+	return o
+}
+
+// build distributes the points of node into its octants, using the same
+// range of scratch as a buffer so that disjoint subtrees build concurrently
+func (o *Octree) build(b *builder, node *octreeNode, scratch []int32, depth int) {
+	if node.end-node.start <= o.leafSize || depth >= maxOctreeDepth || b.canceled(node.end-node.start) {
+		return
+	}
+
+	var center [3]float32
+	for c := range 3 {
+		center[c] = (node.bounds.Min[c] + node.bounds.Max[c]) / 2
+	}
+	octant := func(i int32) int {
+		p := point(o.positions, i)
+		k := 0
+		for c := range 3 {
+			if p[c] > center[c] {
+				k |= 1 << c
+			}
+		}
+		return k
+	}
+
+	idx := o.perm[node.start:node.end]
+	var offsets [9]int
+	for _, i := range idx {
+		offsets[octant(i)+1]++
+	}
+	for k := range 8 {
+		offsets[k+1] += offsets[k]
+	}
+	buf := scratch[node.start:node.end]
+	fill := offsets
+	for _, i := range idx {
+		k := octant(i)
+		buf[fill[k]] = i
+		fill[k]++
+	}
+	copy(idx, buf)
+
+	for k := range 8 {
+		if offsets[k] == offsets[k+1] {
+			continue
+		}
+		child := &octreeNode{start: node.start + offsets[k], end: node.start + offsets[k+1]}
+		for c := range 3 {
+			if k&(1<<c) != 0 {
+				child.bounds.Min[c], child.bounds.Max[c] = center[c], node.bounds.Max[c]
+			} else {
+				child.bounds.Min[c], child.bounds.Max[c] = node.bounds.Min[c], center[c]
+			}
+		}
+		node.children = append(node.children, child)
+		b.spawn(child.end-child.start, func() { o.build(b, child, scratch, depth+1) })
+	}
+}
+
+// Len returns the number of indexed points
+func (o *Octree) Len() int {
+	return len(o.perm)
+}
+
+// KNearest returns the k points nearest to p, nearest first
+func (o *Octree) KNearest(p [3]float32, k int) []int {
+	if k <= 0 {
+		return nil
+	}
+	h := &neighbours{k: k}
+	o.knn(o.root, p, h)
+	return h.sorted()
+}
+
+func (o *Octree) knn(node *octreeNode, p [3]float32, h *neighbours) {
+	if boxDist2(p, node.bounds) > h.bound() {
+		return
+	}
+	if node.children == nil {
+		for _, i := range o.perm[node.start:node.end] {
+			h.add(dist2(p, point(o.positions, i)), i)
+		}
+		return
+	}
+
+	// Visit the nearest octants first to tighten the bound early
+	var order [8]int
+	var dist [8]float32
+	for k, child := range node.children {
+		order[k], dist[k] = k, boxDist2(p, child.bounds)
+		for j := k; j > 0 && dist[order[j]] < dist[order[j-1]]; j-- {
+			order[j], order[j-1] = order[j-1], order[j]
+		}
+	}
+	for _, k := range order[:len(node.children)] {
+		o.knn(node.children[k], p, h)
+	}
+}
+
+// Radius returns the points within distance r of p, in no particular order
+func (o *Octree) Radius(p [3]float32, r float32) []int {
+	var out []int
+	o.radius(o.root, p, r*r, &out)
+	return out
+}
+
+func (o *Octree) radius(node *octreeNode, p [3]float32, r2 float32, out *[]int) {
+	if boxDist2(p, node.bounds) > r2 {
+		return
+	}
+	if node.children == nil {
+		for _, i := range o.perm[node.start:node.end] {
+			if dist2(p, point(o.positions, i)) <= r2 {
+				*out = append(*out, int(i))
+			}
+		}
+		return
+	}
+	for _, child := range node.children {
+		o.radius(child, p, r2, out)
+	}
+}
+
+// Box returns the points inside b, bounds included, in no particular order
+func (o *Octree) Box(b spz.Bounds) []int {
+	var out []int
+	o.box(o.root, b, &out)
+	return out
+}
+
+func (o *Octree) box(node *octreeNode, b spz.Bounds, out *[]int) {
+	if !boxesOverlap(node.bounds, b) {
+		return
+	}
+	if node.children == nil {
+		for _, i := range o.perm[node.start:node.end] {
+			if inBox(point(o.positions, i), b) {
+				*out = append(*out, int(i))
+			}
+		}
+		return
+	}
+	for _, child := range node.children {
+		o.box(child, b, out)
+	}
+}
+
+// Ray returns the points within distance radius of the ray from origin
+// along dir, ordered by distance along the ray
+func (o *Octree) Ray(origin, dir [3]float32, radius float32) []int {
+	r, ok := newRay(origin, dir, radius)
+	if !ok {
+		return nil
+	}
+	hits := &rayHits{}
+	o.ray(o.root, r, hits)
+	return hits.sorted()
+}
+
+func (o *Octree) ray(node *octreeNode, r ray, hits *rayHits) {
+	if !r.crosses(node.bounds) {
+		return
+	}
+	if node.children == nil {
+		for _, i := range o.perm[node.start:node.end] {
+			if d, ok := r.hit(point(o.positions, i)); ok {
+				hits.add(d, i)
+			}
+		}
+		return
+	}
+	for _, child := range node.children {
+		o.ray(child, r, hits)
+	}
+}
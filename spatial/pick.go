@@ -0,0 +1,269 @@
+package spatial
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	spz "github.com/flywave/go-spz"
+	"github.com/flywave/go-spz/internal/cancelcheck"
+)
+
+const (
+	// DefaultPickSigma is the ellipsoid size, in standard deviations, used
+	// when none is given
+	DefaultPickSigma = 3.0
+	// bvhLeafSize is the maximum number of splats in a BVH leaf
+	bvhLeafSize = 4
+)
+
+// Hit is an intersection of a ray with a splat ellipsoid
+type Hit struct {
+	// Index is the splat index
+	Index int
+	// Distance is the distance along the ray at which it enters the ellipsoid
+	Distance float32
+	// Opacity is the linear opacity of the splat
+	Opacity float32
+	// Transmittance is the fraction of light passing all splats hit before
+	// this one
+	Transmittance float32
+	// Weight is the contribution of the splat along the ray, Opacity·Transmittance
+	Weight float32
+}
+
+// ellipsoid is a k-sigma splat ellipsoid, the set of x where
+// (x-center)ᵀ·inv·(x-center) <= 1
+type ellipsoid struct {
+	center [3]float64
+	inv    [3][3]float64
+}
+
+// intersect returns the distance along a normalized ray at which it enters
+// the ellipsoid, zero if the origin is inside
+func (e *ellipsoid) intersect(origin, dir [3]float32) (float64, bool) {
+	var o, d, ao, ad [3]float64
+	for c := range 3 {
+		o[c] = float64(origin[c]) - e.center[c]
+		d[c] = float64(dir[c])
+	}
+	for i := range 3 {
+		for j := range 3 {
+			ao[i] += e.inv[i][j] * o[j]
+			ad[i] += e.inv[i][j] * d[j]
+		}
+	}
+	a := d[0]*ad[0] + d[1]*ad[1] + d[2]*ad[2]
+	b := 2 * (d[0]*ao[0] + d[1]*ao[1] + d[2]*ao[2])
+	c := o[0]*ao[0] + o[1]*ao[1] + o[2]*ao[2] - 1
+	if a <= 0 {
+		return 0, false
+	}
+	disc := b*b - 4*a*c
+	if disc < 0 {
+		return 0, false
+	}
+	sq := math.Sqrt(disc)
+	t0, t1 := (-b-sq)/(2*a), (-b+sq)/(2*a)
+	switch {
+	case t0 >= 0:
+		return t0, true
+	case t1 >= 0:
+		return 0, true
+	}
+	return 0, false
+}
+
+// bvhNode covers items[start:end]; inner nodes have two children
+type bvhNode struct {
+	bounds      spz.Bounds
+	start, end  int
+	left, right int
+}
+
+// Picker finds the splats hit by a ray by intersecting their k-sigma
+// ellipsoids, using a bounding volume hierarchy over the ellipsoid boxes.
+// It is immutable after construction and safe for concurrent use.
+type Picker struct {
+	ellipsoids []ellipsoid
+	opacity    []float32
+	boxes      []spz.Bounds
+	items      []int32
+	nodes      []bvhNode
+}
+
+// NewPicker builds a picker over the sigma-sized ellipsoids of the splats.
+// A sigma of 0 selects DefaultPickSigma. Fully transparent and degenerate
+// splats are never hit.
+func NewPicker(data *spz.SpzData, sigma float64) *Picker {
+	p, _ := NewPickerContext(context.Background(), data, sigma)
+	return p
+}
+
+// NewPickerContext is NewPicker with cancellation: it returns the context
+// error once ctx is canceled
+func NewPickerContext(ctx context.Context, data *spz.SpzData, sigma float64) (*Picker, error) {
+	if sigma <= 0 {
+		sigma = DefaultPickSigma
+	}
+	p := &Picker{
+		ellipsoids: make([]ellipsoid, len(data.Data)),
+		opacity:    make([]float32, len(data.Data)),
+		boxes:      make([]spz.Bounds, len(data.Data)),
+	}
+	for i, d := range data.Data {
+		if err := cancelcheck.Check(ctx, i); err != nil {
+			return nil, err
+		}
+		cov := spz.SplatCovariance(d)
+		e := &p.ellipsoids[i]
+		e.center = [3]float64{float64(d.PositionX), float64(d.PositionY), float64(d.PositionZ)}
+		inv, ok := invert3(cov)
+		if !ok || d.ColorA == 0 {
+			continue
+		}
+		for r := range 3 {
+			for c := range 3 {
+				e.inv[r][c] = inv[r][c] / (sigma * sigma)
+			}
+			ext := sigma * math.Sqrt(cov[r][r])
+			p.boxes[i].Min[r] = float32(e.center[r] - ext)
+			p.boxes[i].Max[r] = float32(e.center[r] + ext)
+		}
+		p.opacity[i] = float32(d.ColorA) / 255
+		p.items = append(p.items, int32(i))
+	}
+	if len(p.items) > 0 {
+		p.build(ctx, 0, len(p.items))
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// invert3 inverts a symmetric 3x3 matrix
+func invert3(m [3][3]float64) ([3][3]float64, bool) {
+	var inv [3][3]float64
+	inv[0][0] = m[1][1]*m[2][2] - m[1][2]*m[2][1]
+	inv[0][1] = m[0][2]*m[2][1] - m[0][1]*m[2][2]
+	inv[0][2] = m[0][1]*m[1][2] - m[0][2]*m[1][1]
+	inv[1][1] = m[0][0]*m[2][2] - m[0][2]*m[2][0]
+	inv[1][2] = m[0][2]*m[1][0] - m[0][0]*m[1][2]
+	inv[2][2] = m[0][0]*m[1][1] - m[0][1]*m[1][0]
+	det := m[0][0]*inv[0][0] + m[0][1]*inv[0][1] + m[0][2]*inv[0][2]
+	if det <= 0 || math.IsNaN(det) || math.IsInf(det, 0) {
+		return inv, false
+	}
+	for i := range 3 {
+		for j := i; j < 3; j++ {
+			inv[i][j] /= det
+			inv[j][i] = inv[i][j]
+		}
+	}
+	return inv, true
+}
+
+// build creates the node for items[start:end], splitting at the median box
+// center along the axis of largest extent, and returns its index. Large
+// nodes are left unsplit once ctx is canceled.
+func (p *Picker) build(ctx context.Context, start, end int) int {
+	node := bvhNode{bounds: p.boxes[p.items[start]], start: start, end: end, left: -1, right: -1}
+	for _, i := range p.items[start+1 : end] {
+		for c := range 3 {
+			node.bounds.Min[c] = min(node.bounds.Min[c], p.boxes[i].Min[c])
+			node.bounds.Max[c] = max(node.bounds.Max[c], p.boxes[i].Max[c])
+		}
+	}
+	index := len(p.nodes)
+	p.nodes = append(p.nodes, node)
+	if end-start <= bvhLeafSize || (end-start >= cancelcheck.Interval && ctx.Err() != nil) {
+		return index
+	}
+
+	axis := 0
+	for c := 1; c < 3; c++ {
+		if node.bounds.Max[c]-node.bounds.Min[c] > node.bounds.Max[axis]-node.bounds.Min[axis] {
+			axis = c
+		}
+	}
+	mid := (start + end) / 2
+	selectNth(p.items[start:end], mid-start, func(i int32) float32 {
+		return p.boxes[i].Min[axis] + p.boxes[i].Max[axis]
+	})
+	left := p.build(ctx, start, mid)
+	right := p.build(ctx, mid, end)
+	p.nodes[index].left, p.nodes[index].right = left, right
+	return index
+}
+
+// Nearest returns the splat whose ellipsoid the ray from origin along dir
+// enters first. The hit has full transmittance.
+func (p *Picker) Nearest(origin, dir [3]float32) (Hit, bool) {
+	r, ok := newRay(origin, dir, 0)
+	if !ok || len(p.nodes) == 0 {
+		return Hit{}, false
+	}
+
+	best := Hit{Index: -1, Distance: float32(math.Inf(1))}
+	stack := []int{0}
+	for len(stack) > 0 {
+		node := &p.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if t, ok := r.enter(node.bounds); !ok || t > best.Distance {
+			continue
+		}
+		if node.left < 0 {
+			for _, i := range p.items[node.start:node.end] {
+				if t, ok := p.ellipsoids[i].intersect(r.origin, r.dir); ok && float32(t) < best.Distance {
+					best = Hit{Index: int(i), Distance: float32(t), Opacity: p.opacity[i]}
+				}
+			}
+			continue
+		}
+		stack = append(stack, node.right, node.left)
+	}
+	if best.Index < 0 {
+		return Hit{}, false
+	}
+	best.Transmittance, best.Weight = 1, best.Opacity
+	return best, true
+}
+
+// All returns every splat whose ellipsoid the ray from origin along dir
+// enters, nearest first, with the transmittance accumulated from the
+// opacities of the splats in front
+func (p *Picker) All(origin, dir [3]float32) []Hit {
+	r, ok := newRay(origin, dir, 0)
+	if !ok || len(p.nodes) == 0 {
+		return nil
+	}
+
+	var hits []Hit
+	stack := []int{0}
+	for len(stack) > 0 {
+		node := &p.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if !r.crosses(node.bounds) {
+			continue
+		}
+		if node.left < 0 {
+			for _, i := range p.items[node.start:node.end] {
+				if t, ok := p.ellipsoids[i].intersect(r.origin, r.dir); ok {
+					hits = append(hits, Hit{Index: int(i), Distance: float32(t), Opacity: p.opacity[i]})
+				}
+			}
+			continue
+		}
+		stack = append(stack, node.right, node.left)
+	}
+
+	sort.SliceStable(hits, func(a, b int) bool { return hits[a].Distance < hits[b].Distance })
+	transmittance := float32(1)
+	for k := range hits {
+		hits[k].Transmittance = transmittance
+		hits[k].Weight = hits[k].Opacity * transmittance
+		transmittance *= 1 - hits[k].Opacity
+	}
+	return hits
+}
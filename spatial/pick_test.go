@@ -0,0 +1,103 @@
+package spatial
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	spz "github.com/flywave/go-spz"
+	"github.com/stretchr/testify/assert"
+)
+
+func pickSplat(x, y, z float32, alpha uint8) *spz.SplatData {
+	s := float32(math.Log(0.1))
+	return &spz.SplatData{
+		PositionX: x, PositionY: y, PositionZ: z,
+		ScaleX: s, ScaleY: s, ScaleZ: s,
+		RotationW: 255, RotationX: 128, RotationY: 128, RotationZ: 128,
+		ColorA: alpha,
+	}
+}
+
+// TestPicker tests ray picking against splat ellipsoids
+func TestPicker(t *testing.T) {
+	// Splat 2 is stretched along x, then rotated 90 degrees about z
+	stretched := pickSplat(4, 0, 0, 255)
+	stretched.ScaleX = 0
+	stretched.RotationW, stretched.RotationZ = 219, 219
+	data := &spz.SpzData{Data: []*spz.SplatData{
+		pickSplat(0, 0, 0, 204),
+		pickSplat(2, 0, 0, 128),
+		stretched,
+		pickSplat(6, 0, 0, 0),
+	}}
+	picker := NewPicker(data, 0)
+
+	hits := picker.All([3]float32{-5, 0, 0}, [3]float32{1, 0, 0})
+	assert.Len(t, hits, 3)
+	for k, h := range hits {
+		assert.Equal(t, k, h.Index)
+		assert.InDelta(t, 4.7+2*float64(k), h.Distance, 1e-3)
+	}
+	assert.Equal(t, float32(1), hits[0].Transmittance)
+	assert.InDelta(t, 0.2, hits[1].Transmittance, 1e-6)
+	assert.InDelta(t, 0.2*(1-128.0/255), hits[2].Transmittance, 1e-6)
+	assert.InDelta(t, 0.2*128.0/255, hits[1].Weight, 1e-6)
+
+	hit, ok := picker.Nearest([3]float32{10, 0, 0}, [3]float32{-2, 0, 0})
+	assert.True(t, ok)
+	assert.Equal(t, 2, hit.Index)
+	assert.InDelta(t, 5.7, hit.Distance, 1e-3)
+
+	// The rotated splat extends 3 units along y
+	hit, ok = picker.Nearest([3]float32{4, -10, 0}, [3]float32{0, 1, 0})
+	assert.True(t, ok)
+	assert.Equal(t, 2, hit.Index)
+	assert.InDelta(t, 7, hit.Distance, 0.05)
+
+	// A ray starting inside a splat hits it at distance 0
+	hit, ok = picker.Nearest([3]float32{0, 0, 0}, [3]float32{0, 0, 1})
+	assert.True(t, ok)
+	assert.Equal(t, 0, hit.Index)
+	assert.Equal(t, float32(0), hit.Distance)
+
+	_, ok = picker.Nearest([3]float32{6, -10, 0}, [3]float32{0, 1, 0})
+	assert.False(t, ok)
+	_, ok = picker.Nearest([3]float32{-5, 0, 0}, [3]float32{-1, 0, 0})
+	assert.False(t, ok)
+}
+
+// TestPickerBVH tests the BVH against intersecting every splat
+func TestPickerBVH(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	data := &spz.SpzData{}
+	for range 2000 {
+		d := pickSplat(rng.Float32()*10-5, rng.Float32()*10-5, rng.Float32()*10-5, 255)
+		d.ScaleX = float32(math.Log(0.05 + rng.Float64()*0.2))
+		d.RotationW, d.RotationX = uint8(rng.Intn(256)), uint8(rng.Intn(256))
+		data.Data = append(data.Data, d)
+	}
+	picker := NewPicker(data, 2)
+
+	for range 50 {
+		origin := [3]float32{rng.Float32()*20 - 10, rng.Float32()*20 - 10, -20}
+		r, _ := newRay(origin, [3]float32{rng.Float32() - 0.5, rng.Float32() - 0.5, 1}, 0)
+
+		var expected []int
+		for i := range picker.ellipsoids {
+			if _, ok := picker.ellipsoids[i].intersect(r.origin, r.dir); ok {
+				expected = append(expected, i)
+			}
+		}
+		var got []int
+		for _, h := range picker.All(r.origin, r.dir) {
+			got = append(got, h.Index)
+		}
+		assert.ElementsMatch(t, expected, got)
+		if len(got) > 0 {
+			hit, ok := picker.Nearest(r.origin, r.dir)
+			assert.True(t, ok)
+			assert.Equal(t, got[0], hit.Index)
+		}
+	}
+}
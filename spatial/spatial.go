@@ -0,0 +1,284 @@
+// Package spatial provides spatial indexes over splat centers. The indexes
+// answer k-nearest-neighbour, radius, box and ray queries with splat indices,
+// are built in parallel and are immutable afterwards, so queries are safe for
+// concurrent readers. A Picker intersects rays with the Gaussian ellipsoids
+// themselves.
+package spatial
+
+import (
+	"container/heap"
+	"context"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+
+	spz "github.com/flywave/go-spz"
+	"github.com/flywave/go-spz/internal/cancelcheck"
+)
+
+// Index is a spatial index over a set of points
+type Index interface {
+	// Len returns the number of indexed points
+	Len() int
+	// KNearest returns the k points nearest to p, nearest first
+	KNearest(p [3]float32, k int) []int
+	// Radius returns the points within distance r of p, in no particular order
+	Radius(p [3]float32, r float32) []int
+	// Box returns the points inside b, bounds included, in no particular order
+	Box(b spz.Bounds) []int
+	// Ray returns the points within distance radius of the ray from origin
+	// along dir, ordered by distance along the ray
+	Ray(origin, dir [3]float32, radius float32) []int
+}
+
+// parallelBuildThreshold is the subtree size above which building is handed
+// to another goroutine
+const parallelBuildThreshold = 1 << 14
+
+// Positions returns the splat centers as a columnar x, y, z buffer
+func Positions(data *spz.SpzData) []float32 {
+	positions := make([]float32, 0, len(data.Data)*3)
+	for _, d := range data.Data {
+		positions = append(positions, d.PositionX, d.PositionY, d.PositionZ)
+	}
+	return positions
+}
+
+func checkPositions(positions []float32) error {
+	if len(positions)%3 != 0 {
+		return &spz.SpzError{Message: "Invalid positions: length must be a multiple of 3"}
+	}
+	return nil
+}
+
+// builder runs subtree builds on a bounded number of goroutines and stops
+// them once its context is canceled
+type builder struct {
+	ctx context.Context
+	sem chan struct{}
+	wg  sync.WaitGroup
+}
+
+func newBuilder(ctx context.Context) *builder {
+	return &builder{ctx: ctx, sem: make(chan struct{}, runtime.GOMAXPROCS(0))}
+}
+
+// canceled reports whether building should stop before a subtree of the
+// given size. Small subtrees are finished without checking.
+func (b *builder) canceled(size int) bool {
+	return size >= cancelcheck.Interval && b.ctx.Err() != nil
+}
+
+// spawn runs f on another goroutine when the subtree is large and a worker
+// is free, and inline otherwise
+func (b *builder) spawn(size int, f func()) {
+	if size >= parallelBuildThreshold {
+		select {
+		case b.sem <- struct{}{}:
+			b.wg.Add(1)
+			go func() {
+				defer func() {
+					<-b.sem
+					b.wg.Done()
+				}()
+				f()
+			}()
+			return
+		default:
+		}
+	}
+	f()
+}
+
+// wait waits for the spawned builds and returns the context error if the
+// build was canceled
+func (b *builder) wait() error {
+	b.wg.Wait()
+	return b.ctx.Err()
+}
+
+func point(positions []float32, i int32) [3]float32 {
+	return [3]float32{positions[3*i], positions[3*i+1], positions[3*i+2]}
+}
+
+func dist2(a, b [3]float32) float32 {
+	dx, dy, dz := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dx*dx + dy*dy + dz*dz
+}
+
+func inBox(p [3]float32, b spz.Bounds) bool {
+	for c := range 3 {
+		if p[c] < b.Min[c] || p[c] > b.Max[c] {
+			return false
+		}
+	}
+	return true
+}
+
+func boxesOverlap(a, b spz.Bounds) bool {
+	for c := range 3 {
+		if a.Max[c] < b.Min[c] || a.Min[c] > b.Max[c] {
+			return false
+		}
+	}
+	return true
+}
+
+// boxDist2 returns the squared distance from p to the nearest point of b
+func boxDist2(p [3]float32, b spz.Bounds) float32 {
+	var d float32
+	for c := range 3 {
+		if p[c] < b.Min[c] {
+			d += (b.Min[c] - p[c]) * (b.Min[c] - p[c])
+		} else if p[c] > b.Max[c] {
+			d += (p[c] - b.Max[c]) * (p[c] - b.Max[c])
+		}
+	}
+	return d
+}
+
+// boundsOf returns the bounding box of the points in idx
+func boundsOf(positions []float32, idx []int32) spz.Bounds {
+	b := spz.Bounds{
+		Min: [3]float32{float32(math.Inf(1)), float32(math.Inf(1)), float32(math.Inf(1))},
+		Max: [3]float32{float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1))},
+	}
+	for _, i := range idx {
+		p := point(positions, i)
+		for c := range 3 {
+			b.Min[c] = min(b.Min[c], p[c])
+			b.Max[c] = max(b.Max[c], p[c])
+		}
+	}
+	return b
+}
+
+// ray is a normalized query ray with the radius of its capsule
+type ray struct {
+	origin, dir [3]float32
+	radius      float32
+}
+
+func newRay(origin, dir [3]float32, radius float32) (ray, bool) {
+	l := float32(math.Sqrt(float64(dir[0]*dir[0] + dir[1]*dir[1] + dir[2]*dir[2])))
+	if l == 0 || math.IsNaN(float64(l)) {
+		return ray{}, false
+	}
+	return ray{origin, [3]float32{dir[0] / l, dir[1] / l, dir[2] / l}, max(radius, 0)}, true
+}
+
+// hit returns the distance of p along the ray and whether p lies within the
+// ray radius in front of the origin
+func (r ray) hit(p [3]float32) (float32, bool) {
+	v := [3]float32{p[0] - r.origin[0], p[1] - r.origin[1], p[2] - r.origin[2]}
+	t := v[0]*r.dir[0] + v[1]*r.dir[1] + v[2]*r.dir[2]
+	if t < 0 {
+		return 0, false
+	}
+	return t, v[0]*v[0]+v[1]*v[1]+v[2]*v[2]-t*t <= r.radius*r.radius
+}
+
+// crosses reports whether the ray passes through b grown by the ray radius
+func (r ray) crosses(b spz.Bounds) bool {
+	_, ok := r.enter(b)
+	return ok
+}
+
+// enter returns the distance along the ray at which it enters b grown by
+// the ray radius, zero if the origin is inside
+func (r ray) enter(b spz.Bounds) (float32, bool) {
+	tmin, tmax := float32(0), float32(math.Inf(1))
+	for c := range 3 {
+		lo, hi := b.Min[c]-r.radius, b.Max[c]+r.radius
+		if r.dir[c] == 0 {
+			if r.origin[c] < lo || r.origin[c] > hi {
+				return 0, false
+			}
+			continue
+		}
+		t0, t1 := (lo-r.origin[c])/r.dir[c], (hi-r.origin[c])/r.dir[c]
+		if t0 > t1 {
+			t0, t1 = t1, t0
+		}
+		tmin, tmax = max(tmin, t0), min(tmax, t1)
+		if tmin > tmax {
+			return 0, false
+		}
+	}
+	return tmin, true
+}
+
+// rayHits collects points hit by a ray and orders them along it
+type rayHits struct {
+	t   []float32
+	idx []int
+}
+
+func (h *rayHits) add(t float32, i int32) {
+	h.t = append(h.t, t)
+	h.idx = append(h.idx, int(i))
+}
+
+func (h *rayHits) Len() int           { return len(h.idx) }
+func (h *rayHits) Less(i, j int) bool { return h.t[i] < h.t[j] }
+func (h *rayHits) Swap(i, j int) {
+	h.t[i], h.t[j] = h.t[j], h.t[i]
+	h.idx[i], h.idx[j] = h.idx[j], h.idx[i]
+}
+
+func (h *rayHits) sorted() []int {
+	sort.Stable(h)
+	return h.idx
+}
+
+// neighbours is a bounded max-heap of the k nearest candidates
+type neighbours struct {
+	k    int
+	dist []float32
+	idx  []int32
+}
+
+func (h *neighbours) Len() int           { return len(h.idx) }
+func (h *neighbours) Less(i, j int) bool { return h.dist[i] > h.dist[j] }
+func (h *neighbours) Swap(i, j int) {
+	h.dist[i], h.dist[j] = h.dist[j], h.dist[i]
+	h.idx[i], h.idx[j] = h.idx[j], h.idx[i]
+}
+func (h *neighbours) Push(x any) {}
+func (h *neighbours) Pop() any {
+	n := len(h.idx) - 1
+	h.dist, h.idx = h.dist[:n], h.idx[:n]
+	return nil
+}
+
+// bound returns the squared distance a candidate must beat
+func (h *neighbours) bound() float32 {
+	if len(h.idx) < h.k {
+		return float32(math.Inf(1))
+	}
+	return h.dist[0]
+}
+
+func (h *neighbours) add(d float32, i int32) {
+	if len(h.idx) < h.k {
+		h.dist = append(h.dist, d)
+		h.idx = append(h.idx, i)
+		heap.Fix(h, len(h.idx)-1)
+		return
+	}
+	if d < h.dist[0] {
+		h.dist[0], h.idx[0] = d, i
+		heap.Fix(h, 0)
+	}
+}
+
+// sorted returns the candidates nearest first
+func (h *neighbours) sorted() []int {
+	out := make([]int, len(h.idx))
+	for n := len(h.idx) - 1; n >= 0; n-- {
+		out[n] = int(h.idx[0])
+		heap.Pop(h)
+	}
+	return out
+}
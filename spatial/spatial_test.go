@@ -0,0 +1,123 @@
+package spatial
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"testing"
+
+	spz "github.com/flywave/go-spz"
+	"github.com/stretchr/testify/assert"
+)
+
+func randomPositions(n int) []float32 {
+	rng := rand.New(rand.NewSource(1))
+	positions := make([]float32, n*3)
+	for i := range positions {
+		positions[i] = rng.Float32()*20 - 10
+	}
+	// Coincident points must not break the octree
+	copy(positions[3:9], []float32{1, 1, 1, 1, 1, 1})
+	copy(positions[0:3], []float32{1, 1, 1})
+	return positions
+}
+
+func bruteForce(positions []float32, keep func(p [3]float32) bool) []int {
+	var out []int
+	for i := range len(positions) / 3 {
+		if keep(point(positions, int32(i))) {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+func sorted(idx []int) []int {
+	sort.Ints(idx)
+	return idx
+}
+
+// TestIndexes tests both indexes against brute force, with a point count
+// large enough to build in parallel
+func TestIndexes(t *testing.T) {
+	positions := randomPositions(parallelBuildThreshold * 3)
+	kd, err := NewKDTree(positions)
+	assert.NoError(t, err)
+	oct, err := NewOctree(positions, 0)
+	assert.NoError(t, err)
+
+	_, err = NewKDTree(positions[:4])
+	assert.Error(t, err)
+
+	queries := [][3]float32{{0, 0, 0}, {1, 1, 1}, {9, -9, 3}, {30, 0, 0}}
+	for _, index := range []Index{kd, oct} {
+		assert.Equal(t, len(positions)/3, index.Len())
+
+		for _, q := range queries {
+			all := bruteForce(positions, func([3]float32) bool { return true })
+			sort.SliceStable(all, func(a, b int) bool {
+				return dist2(q, point(positions, int32(all[a]))) < dist2(q, point(positions, int32(all[b])))
+			})
+			knn := index.KNearest(q, 10)
+			assert.Len(t, knn, 10)
+			for k, i := range knn {
+				// Ties may come in any order, compare distances
+				assert.Equal(t, dist2(q, point(positions, int32(all[k]))), dist2(q, point(positions, int32(i))))
+			}
+
+			expected := bruteForce(positions, func(p [3]float32) bool { return dist2(q, p) <= 1.5*1.5 })
+			assert.Equal(t, expected, sorted(index.Radius(q, 1.5)))
+		}
+
+		b := spz.Bounds{Min: [3]float32{-1, 2, -3}, Max: [3]float32{1, 4, 0}}
+		assert.Equal(t, bruteForce(positions, func(p [3]float32) bool { return inBox(p, b) }), sorted(index.Box(b)))
+
+		r, _ := newRay([3]float32{-20, 1, 1}, [3]float32{2, 0, 0}, 0.3)
+		hits := index.Ray(r.origin, [3]float32{2, 0, 0}, r.radius)
+		assert.Contains(t, hits, 0)
+		assert.Equal(t, bruteForce(positions, func(p [3]float32) bool { _, ok := r.hit(p); return ok }), sorted(append([]int(nil), hits...)))
+		for k := 1; k < len(hits); k++ {
+			assert.LessOrEqual(t, positions[3*hits[k-1]], positions[3*hits[k]])
+		}
+		assert.Empty(t, index.Ray([3]float32{-20, 1, 1}, [3]float32{-1, 0, 0}, 0.3))
+	}
+}
+
+// TestEmptyIndex tests queries on indexes without points
+func TestEmptyIndex(t *testing.T) {
+	data := &spz.SpzData{}
+	for _, index := range []Index{NewKDTreeFromSpz(data), NewOctreeFromSpz(data, 0)} {
+		assert.Equal(t, 0, index.Len())
+		assert.Empty(t, index.KNearest([3]float32{}, 3))
+		assert.Empty(t, index.Radius([3]float32{}, 1))
+		assert.Empty(t, index.Ray([3]float32{}, [3]float32{1, 0, 0}, 1))
+	}
+}
+
+// TestBuildContext tests that building stops once the context is canceled
+func TestBuildContext(t *testing.T) {
+	positions := randomPositions(20000)
+	data := &spz.SpzData{}
+	for i := 0; i < len(positions); i += 3 {
+		data.Data = append(data.Data, pickSplat(positions[i], positions[i+1], positions[i+2], 255))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tree, err := NewKDTreeContext(ctx, positions)
+	assert.NoError(t, err)
+	assert.Equal(t, len(data.Data), tree.Len())
+	octree, err := NewOctreeContext(ctx, positions, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, len(data.Data), octree.Len())
+	picker, err := NewPickerContext(ctx, data, 0)
+	assert.NoError(t, err)
+	assert.NotNil(t, picker)
+
+	cancel()
+	_, err = NewKDTreeContext(ctx, positions)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = NewOctreeContext(ctx, positions, 0)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = NewPickerContext(ctx, data, 0)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -1,6 +1,9 @@
 package spz
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"strconv"
+)
 
 const (
 	HeaderSizeSpz = 16
@@ -22,13 +25,21 @@ type SpzData struct {
 
 	// Data fields
 	Data []*SplatData
+
+	// Metadata holds the optional extension entries
+	Metadata Metadata
 }
 
-// ToBytes converts SpzData header to bytes
+// ToBytes converts SpzData header to bytes. FlagSHCodebook also marks the
+// version, as ParseSpzHeader expects.
 func (h *SpzData) ToBytes() []byte {
 	bts := make([]byte, HeaderSizeSpz)
 	binary.LittleEndian.PutUint32(bts[0:4], h.Magic)
-	binary.LittleEndian.PutUint32(bts[4:8], h.Version)
+	version := h.Version
+	if h.Flags&FlagSHCodebook != 0 {
+		version |= shCodebookVersion
+	}
+	binary.LittleEndian.PutUint32(bts[4:8], version)
 	binary.LittleEndian.PutUint32(bts[8:12], h.NumPoints)
 	bts[12] = h.ShDegree
 	bts[13] = h.FractionalBits
@@ -77,14 +88,19 @@ func ParseSpzHeader(data []byte) (*SpzData, error) {
 	if spzData.Magic != SPZ_MAGIC {
 		return nil, &SpzError{"Invalid SPZ file: magic number mismatch"}
 	}
+	codebook := spzData.Version&shCodebookVersion != 0
+	spzData.Version &^= shCodebookVersion
+	if codebook != (spzData.Flags&FlagSHCodebook != 0) {
+		return nil, &SpzError{"Invalid SPZ file: SH codebook flag does not match the version"}
+	}
 	if spzData.Version < 2 || spzData.Version > 3 {
-		return nil, &SpzError{"Unsupported SPZ version: " + string(rune(spzData.Version))}
+		return nil, &SpzError{"Unsupported SPZ version: " + strconv.Itoa(int(spzData.Version))}
 	}
 	if spzData.ShDegree > 3 {
-		return nil, &SpzError{"Unsupported SH degree: " + string(rune(spzData.ShDegree))}
+		return nil, &SpzError{"Unsupported SH degree: " + strconv.Itoa(int(spzData.ShDegree))}
 	}
-	if spzData.FractionalBits != 12 {
-		return nil, &SpzError{"Unsupported fractional bits: " + string(rune(spzData.FractionalBits))}
+	if spzData.FractionalBits != positionFractionalBits {
+		return nil, &SpzError{"Unsupported fractional bits: " + strconv.Itoa(int(spzData.FractionalBits))}
 	}
 
 	return spzData, nil
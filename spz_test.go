@@ -1,7 +1,10 @@
 package spz
 
 import (
+	"encoding/json"
+	"math"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -285,3 +288,201 @@ func TestEmptyData(t *testing.T) {
 	assert.Equal(t, uint32(0), readData.NumPoints)
 	assert.Equal(t, 0, len(readData.Data))
 }
+
+// TestMeasureRoundTripError tests the precision report of an SPZ round trip
+func TestMeasureRoundTripError(t *testing.T) {
+	originalData := &SpzData{
+		Magic:          SPZ_MAGIC,
+		Version:        3,
+		NumPoints:      2,
+		ShDegree:       1,
+		FractionalBits: 12,
+		Data: []*SplatData{
+			{
+				PositionX: 1.23456, PositionY: -2.5, PositionZ: 3000,
+				ScaleX: 0.1, ScaleY: -12, ScaleZ: 0.3,
+				RotationW: 200, RotationX: 150, RotationY: 110, RotationZ: 128,
+				ColorR: 255, ColorG: 128, ColorB: 64, ColorA: 200,
+				SH1: []byte{100, 110, 120, 130, 140, 150, 160, 170, 180},
+			},
+			{
+				PositionX: -1.5, PositionY: 0.001, PositionZ: 7,
+				ScaleX: 0.4, ScaleY: 0.5, ScaleZ: 0.6,
+				RotationW: 255, RotationX: 128, RotationY: 128, RotationZ: 128,
+				ColorR: 64, ColorG: 128, ColorB: 255, ColorA: 180,
+				SH1: []byte{90, 100, 110, 120, 130, 140, 150, 160, 170},
+			},
+		},
+	}
+
+	bts, err := Encode(originalData)
+	assert.NoError(t, err)
+	readData, err := Decode(bts)
+	assert.NoError(t, err)
+
+	report, err := MeasureRoundTripError(originalData, readData)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.NumPoints)
+	assert.Equal(t, 1, report.PositionOverflows)
+	assert.Equal(t, 1, report.ClampedScales)
+	assert.Equal(t, 6, report.Position.Count)
+	assert.Greater(t, report.Position.Max, 1000.0)
+	assert.InDelta(t, 2.0, report.Scale.Max, 0.01)
+	assert.Less(t, report.Rotation.Max, 2.0)
+	assert.Equal(t, 0.0, report.Opacity.Max)
+	assert.Equal(t, 18, report.SH.Count)
+	assert.LessOrEqual(t, report.SH.Max, 4.0/128)
+	assert.LessOrEqual(t, report.SH.Mean, report.SH.RMS)
+
+	_, err = MeasureRoundTripError(originalData, &SpzData{})
+	assert.Error(t, err)
+}
+
+// TestPositionOverflows tests the exact range of encodable positions
+func TestPositionOverflows(t *testing.T) {
+	// Positions are float32, which hold these values exactly
+	const step = 1.0 / (1 << positionFractionalBits)
+	for _, tc := range []struct {
+		pos      float64
+		overflow bool
+	}{
+		{-2048, false},
+		{-2048 - step, true},
+		{2048 - step, false},
+		{2048 - 0.5*step, true},
+		{2048, true},
+	} {
+		assert.Equal(t, tc.overflow, positionOverflows(tc.pos), "position %v", tc.pos)
+		// Encodable positions decode to their nearest fixed-point value
+		decoded := float64(spzDecodePosition(encodeFloat32ToBytes3(float32(tc.pos)), positionFractionalBits))
+		assert.Equal(t, !tc.overflow, math.Abs(decoded-tc.pos) <= step/2, "position %v", tc.pos)
+	}
+}
+
+// TestDecodeRange tests random-access decoding of a subset of splats
+func TestDecodeRange(t *testing.T) {
+	data := newCompressTestData()
+	data.Metadata = Metadata{"note": []byte("x")}
+	bts := mustEncode(t, data, nil)
+	full, err := Decode(bts)
+	assert.NoError(t, err)
+
+	header, payload, err := DecompressSpz(bts)
+	assert.NoError(t, err)
+	assert.Nil(t, header.Data)
+	assert.Nil(t, header.Metadata)
+	header, payload, err = DecompressSpzWithOptions(bts, &DecodeOptions{Metadata: true})
+	assert.NoError(t, err)
+	assert.Equal(t, data.Metadata, header.Metadata)
+
+	splats, err := DecodeRange(payload, header, 1, 2, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, full.Data[1:], splats)
+	splats, err = DecodeRange(payload, header, 0, 0, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, splats)
+	_, err = DecodeRange(payload, header, 1, 3, nil)
+	assert.Error(t, err)
+	_, err = DecodeRange(payload[:len(payload)-1], header, 0, 1, nil)
+	assert.Error(t, err)
+
+	splats, err = DecodeIndices(payload, header, []int{1, 0, 1}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []*SplatData{full.Data[1], full.Data[0], full.Data[1]}, splats)
+	_, err = DecodeIndices(payload, header, []int{2}, nil)
+	assert.Error(t, err)
+
+	// SH codebook payloads are decoded through the palette
+	bts, err = EncodeWithOptions(data, &EncodeOptions{SHCodebookSize: 1})
+	assert.NoError(t, err)
+	full, err = DecodeWithOptions(bts, &DecodeOptions{SHCodebook: true})
+	assert.NoError(t, err)
+	header, payload, err = DecompressSpz(bts)
+	assert.NoError(t, err)
+	_, err = DecodeIndices(payload, header, []int{1}, nil)
+	assert.Error(t, err)
+	_, err = DecodeRange(payload, header, 0, 1, nil)
+	assert.Error(t, err)
+	splats, err = DecodeIndices(payload, header, []int{1}, &DecodeOptions{SHCodebook: true})
+	assert.NoError(t, err)
+	assert.Equal(t, full.Data[1], splats[0])
+	splats, err = DecodeRange(payload, header, 0, 2, &DecodeOptions{SHCodebook: true, Mask: MaskSH})
+	assert.NoError(t, err)
+	assert.Equal(t, full.Data[0].SH1, splats[0].SH1)
+	assert.Zero(t, splats[0].PositionX)
+}
+
+// TestDecodeMask tests attribute-selective decoding
+func TestDecodeMask(t *testing.T) {
+	bts := mustEncode(t, newCompressTestData(), NoneCompressor{})
+	full, err := Decode(bts)
+	assert.NoError(t, err)
+
+	data, err := DecodeWithOptions(bts, &DecodeOptions{Mask: MaskPositions | MaskColor})
+	assert.NoError(t, err)
+	for i, d := range data.Data {
+		assert.Equal(t, full.Data[i].PositionY, d.PositionY)
+		assert.Equal(t, full.Data[i].ColorB, d.ColorB)
+		assert.Zero(t, d.ColorA)
+		assert.Zero(t, d.ScaleX)
+		assert.Zero(t, d.RotationW)
+		assert.Nil(t, d.SH1)
+	}
+
+	data, err = DecodeWithOptions(bts, &DecodeOptions{Mask: MaskSH})
+	assert.NoError(t, err)
+	assert.Equal(t, full.Data[1].SH1, data.Data[1].SH1)
+	assert.Zero(t, data.Data[1].PositionX)
+
+	// The payload size is validated even for skipped sections
+	_, err = DecodeWithOptions(bts[:len(bts)-1], &DecodeOptions{Mask: MaskPositions})
+	assert.Error(t, err)
+}
+
+// TestDecodeLegacyEncoding decodes files written by the baseline encoder,
+// which stored colors with a color scale of 2.0 and version 3 rotations in
+// w, x, y, z order, and compares them with what the baseline decoder read
+// back from them
+func TestDecodeLegacyEncoding(t *testing.T) {
+	for _, name := range []string{"baseline_v2_sh1", "baseline_v3_sh1"} {
+		t.Run(name, func(t *testing.T) {
+			bts, err := os.ReadFile(filepath.Join("testdata", "legacy", name+".spz"))
+			assert.NoError(t, err)
+			js, err := os.ReadFile(filepath.Join("testdata", "legacy", name+".json"))
+			assert.NoError(t, err)
+			var expected struct {
+				Splats []struct {
+					Position [3]float32 `json:"position"`
+					Scale    [3]float32 `json:"scale"`
+					Rotation [4]uint8   `json:"rotation"`
+					Color    [4]uint8   `json:"color"`
+					SH1      []int      `json:"sh1"`
+				} `json:"splats"`
+			}
+			assert.NoError(t, json.Unmarshal(js, &expected))
+
+			data, err := DecodeWithOptions(bts, &DecodeOptions{LegacyEncoding: true})
+			assert.NoError(t, err)
+			if !assert.Len(t, data.Data, len(expected.Splats)) {
+				return
+			}
+			for i, s := range expected.Splats {
+				want := &SplatData{
+					PositionX: s.Position[0], PositionY: s.Position[1], PositionZ: s.Position[2],
+					ScaleX: s.Scale[0], ScaleY: s.Scale[1], ScaleZ: s.Scale[2],
+					RotationW: s.Rotation[0], RotationX: s.Rotation[1], RotationY: s.Rotation[2], RotationZ: s.Rotation[3],
+					ColorR: s.Color[0], ColorG: s.Color[1], ColorB: s.Color[2], ColorA: s.Color[3],
+				}
+				for _, v := range s.SH1 {
+					want.SH1 = append(want.SH1, uint8(v))
+				}
+				assert.Equal(t, want, data.Data[i], "splat %d", i)
+			}
+
+			// The current encoding reads the same bytes as other colors
+			current, err := Decode(bts)
+			assert.NoError(t, err)
+			assert.NotEqual(t, data.Data[0].ColorR, current.Data[0].ColorR)
+		})
+	}
+}
@@ -0,0 +1,190 @@
+// Package spzhttp serves SPZ files over HTTP, transcoding them on request.
+//
+// Files are served as stored, with a content type, a strong ETag of the file
+// bytes and Range support. Query parameters select a transcode:
+//
+//	format=spz|ply|splat|glb  output format
+//	sh=0..3                   target SH degree
+//	max=N                     keep at most N splats, the most visible first
+//	crop=x0,y0,z0,x1,y1,z1    keep splats whose center lies in the box
+//
+// Transcodes carry a weak ETag and are cached on disk, keyed by the file
+// hash and parameters.
+package spzhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Content types of the output formats
+const (
+	ContentTypeSpz   = "application/x-spz"
+	ContentTypePly   = "application/ply"
+	ContentTypeSplat = "application/octet-stream"
+	ContentTypeGlb   = "model/gltf-binary"
+)
+
+// hashEntry memoizes the hash of a file version
+type hashEntry struct {
+	modTime time.Time
+	size    int64
+	hash    string
+}
+
+// Handler serves the SPZ files of a directory
+type Handler struct {
+	root     string
+	cacheDir string
+
+	mu     sync.Mutex
+	hashes map[string]hashEntry
+}
+
+// NewHandler returns a handler serving the SPZ files under root. Transcodes
+// are cached in cacheDir, which is created if needed; an empty cacheDir
+// disables the cache.
+func NewHandler(root, cacheDir string) *Handler {
+	return &Handler{root: root, cacheDir: cacheDir, hashes: map[string]hashEntry{}}
+}
+
+// fileHash returns the SHA-256 of the bytes of a file, hashing it only when
+// the file changed. It leaves f positioned at its start.
+func (h *Handler) fileHash(file string, f *os.File, info os.FileInfo) (string, error) {
+	h.mu.Lock()
+	entry, ok := h.hashes[file]
+	h.mu.Unlock()
+	if ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.hash, nil
+	}
+
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(sum.Sum(nil))
+	h.mu.Lock()
+	h.hashes[file] = hashEntry{modTime: info.ModTime(), size: info.Size(), hash: hash}
+	h.mu.Unlock()
+	return hash, nil
+}
+
+// ServeHTTP serves a file as stored, or transcoded according to the query
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := path.Clean("/" + r.URL.Path)
+	if !strings.EqualFold(path.Ext(name), ".spz") {
+		http.NotFound(w, r)
+		return
+	}
+	file := filepath.Join(h.root, filepath.FromSlash(name))
+	f, err := os.Open(file)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	params, err := parseParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := h.fileHash(file, f, info)
+	if err != nil {
+		http.Error(w, "cannot read file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", params.contentType())
+	if params.identity() {
+		// The file bytes are served as stored, so the ETag is strong
+		w.Header().Set("ETag", `"`+hash+`"`)
+		http.ServeContent(w, r, name, info.ModTime(), f)
+		return
+	}
+
+	// Transcodes are only equivalent in content, not byte for byte across
+	// encoder changes, so their ETag is weak
+	key := params.key(hash)
+	out, err := h.transcode(r.Context(), f, params, key)
+	if err != nil {
+		http.Error(w, "transcoding failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", `W/"`+key+`"`)
+	http.ServeContent(w, r, name, info.ModTime(), bytes.NewReader(out))
+}
+
+// transcode converts a file, going through the disk cache; the file is
+// only read on a cache miss. Transcoding stops when ctx is canceled, e.g.
+// because the client went away.
+func (h *Handler) transcode(ctx context.Context, f *os.File, params *transcodeParams, key string) ([]byte, error) {
+	cached := ""
+	if h.cacheDir != "" {
+		cached = filepath.Join(h.cacheDir, key+"."+params.format)
+		if out, err := os.ReadFile(cached); err == nil {
+			return out, nil
+		}
+	}
+
+	bts, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	out, err := transcode(ctx, bts, params)
+	if err != nil {
+		return nil, err
+	}
+	if cached != "" {
+		// A failure to cache only costs a transcode on the next request
+		_ = writeCacheFile(h.cacheDir, cached, out)
+	}
+	return out, nil
+}
+
+// writeCacheFile writes through a temporary file so that concurrent
+// readers never see a partial transcode
+func writeCacheFile(dir, file string, bts []byte) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".transcode-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(bts); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,177 @@
+package spzhttp
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	spz "github.com/flywave/go-spz"
+	"github.com/stretchr/testify/assert"
+)
+
+func newHandlerTestData() *spz.SpzData {
+	return &spz.SpzData{
+		Magic:          spz.SPZ_MAGIC,
+		Version:        3,
+		NumPoints:      2,
+		ShDegree:       1,
+		FractionalBits: 12,
+		Data: []*spz.SplatData{
+			{
+				PositionX: 1, PositionY: 1, PositionZ: 1,
+				ScaleX: -2, ScaleY: -2, ScaleZ: -2,
+				RotationW: 255, RotationX: 128, RotationY: 128, RotationZ: 128,
+				ColorR: 200, ColorG: 100, ColorB: 50, ColorA: 255,
+				SH1: []byte{100, 110, 120, 130, 140, 150, 160, 170, 180},
+			},
+			{
+				PositionX: -1, PositionY: -1, PositionZ: -1,
+				ScaleX: -4, ScaleY: -4, ScaleZ: -4,
+				RotationW: 255, RotationX: 128, RotationY: 128, RotationZ: 128,
+				ColorR: 50, ColorG: 100, ColorB: 200, ColorA: 128,
+				SH1: []byte{90, 100, 110, 120, 130, 140, 150, 160, 170},
+			},
+		},
+	}
+}
+
+func get(t *testing.T, h http.Handler, target string, header map[string]string) (*http.Response, []byte) {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	body, err := io.ReadAll(rec.Result().Body)
+	assert.NoError(t, err)
+	return rec.Result(), body
+}
+
+// TestHandler tests serving, conditional and range requests and transcodes
+func TestHandler(t *testing.T) {
+	root, cacheDir := t.TempDir(), filepath.Join(t.TempDir(), "cache")
+	file := filepath.Join(root, "scene.spz")
+	assert.NoError(t, spz.WriteSpz(file, newHandlerTestData()))
+	stored, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	sum := sha256.Sum256(stored)
+	hash := hex.EncodeToString(sum[:])
+	h := NewHandler(root, cacheDir)
+
+	resp, body := get(t, h, "/scene.spz", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, ContentTypeSpz, resp.Header.Get("Content-Type"))
+	assert.Equal(t, `"`+hash+`"`, resp.Header.Get("ETag"))
+	assert.Equal(t, stored, body)
+
+	resp, _ = get(t, h, "/scene.spz", map[string]string{"If-None-Match": `"` + hash + `"`})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	resp, body = get(t, h, "/scene.spz", map[string]string{"Range": "bytes=2-5"})
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, stored[2:6], body)
+
+	resp, body = get(t, h, "/scene.spz?format=ply", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, ContentTypePly, resp.Header.Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(string(body), "ply\n"))
+	assert.True(t, strings.HasPrefix(resp.Header.Get("ETag"), `W/"`))
+	assert.NotContains(t, resp.Header.Get("ETag"), hash)
+
+	// The second request is served from the disk cache
+	cached, err := filepath.Glob(filepath.Join(cacheDir, "*.ply"))
+	assert.NoError(t, err)
+	assert.Len(t, cached, 1)
+	assert.NoError(t, os.WriteFile(cached[0], []byte("cached"), 0o644))
+	_, body = get(t, h, "/scene.spz?format=ply", nil)
+	assert.Equal(t, "cached", string(body))
+
+	_, body = get(t, h, "/scene.spz?max=1&sh=0", nil)
+	data, err := spz.Decode(body)
+	assert.NoError(t, err)
+	assert.Len(t, data.Data, 1)
+	assert.Equal(t, uint8(0), data.ShDegree)
+	assert.InDelta(t, 1, data.Data[0].PositionX, 1e-3)
+
+	_, body = get(t, h, "/scene.spz?crop=-2,-2,-2,0,0,0", nil)
+	data, err = spz.Decode(body)
+	assert.NoError(t, err)
+	assert.Len(t, data.Data, 1)
+	assert.InDelta(t, -1, data.Data[0].PositionX, 1e-3)
+
+	resp, body = get(t, h, "/scene.spz?format=splat", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, body, 2*spz.SplatRecordSize)
+	resp, body = get(t, h, "/scene.spz?format=glb", nil)
+	assert.Equal(t, ContentTypeGlb, resp.Header.Get("Content-Type"))
+	assert.Equal(t, "glTF", string(body[:4]))
+
+	for target, status := range map[string]int{
+		"/scene.spz?format=exe":  http.StatusBadRequest,
+		"/scene.spz?crop=1,2":    http.StatusBadRequest,
+		"/scene.spz?sh=4":        http.StatusBadRequest,
+		"/missing.spz":           http.StatusNotFound,
+		"/../scene.spz?max=1":    http.StatusOK,
+		"/scene.ply":             http.StatusNotFound,
+		"/../../etc/passwd.spz":  http.StatusNotFound,
+		"/scene.spz?max=1&sh=-1": http.StatusBadRequest,
+	} {
+		resp, _ := get(t, h, target, nil)
+		assert.Equal(t, status, resp.StatusCode, target)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/scene.spz", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+// TestHandlerRepresentations tests that differently encoded files of the same
+// cloud get distinct ETags, and that chunked files and empty crops are served
+func TestHandlerRepresentations(t *testing.T) {
+	root := t.TempDir()
+	data := newHandlerTestData()
+	gz, err := spz.Encode(data)
+	assert.NoError(t, err)
+	raw, err := spz.EncodeWithCompressor(data, spz.NoneCompressor{})
+	assert.NoError(t, err)
+	chunked, err := spz.EncodeChunked(data, &spz.ChunkedOptions{ChunkSize: 1})
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "gzip.spz"), gz, 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "raw.spz"), raw, 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "chunked.spz"), chunked, 0o644))
+	h := NewHandler(root, "")
+
+	gzResp, body := get(t, h, "/gzip.spz", nil)
+	assert.Equal(t, gz, body)
+	rawResp, body := get(t, h, "/raw.spz", nil)
+	assert.Equal(t, raw, body)
+	assert.NotEqual(t, gzResp.Header.Get("ETag"), rawResp.Header.Get("ETag"))
+
+	resp, body := get(t, h, "/chunked.spz", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, chunked, body)
+	resp, body = get(t, h, "/chunked.spz?format=spz&sh=0", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	got, err := spz.Decode(body)
+	assert.NoError(t, err)
+	assert.Len(t, got.Data, 2)
+
+	// A crop without splats is an empty glTF scene
+	resp, body = get(t, h, "/gzip.spz?format=glb&crop=10,10,10,11,11,11", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	jsonLen := binary.LittleEndian.Uint32(body[12:])
+	assert.Len(t, body, 20+int(jsonLen))
+	var doc map[string]any
+	assert.NoError(t, json.Unmarshal(body[20:], &doc))
+	assert.NotContains(t, doc, "accessors")
+	assert.NotContains(t, doc, "buffers")
+}
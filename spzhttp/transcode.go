@@ -0,0 +1,176 @@
+package spzhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	spz "github.com/flywave/go-spz"
+)
+
+// transcodeParams holds the parsed query parameters of a request
+type transcodeParams struct {
+	format string
+	// sh is the target SH degree, or -1 to keep it
+	sh int
+	// max is the maximum number of splats, or 0 for all
+	max  int
+	crop *spz.Bounds
+}
+
+func parseParams(q url.Values) (*transcodeParams, error) {
+	p := &transcodeParams{format: "spz", sh: -1}
+	if v := q.Get("format"); v != "" {
+		if !slices.Contains([]string{"spz", "ply", "splat", "glb"}, v) {
+			return nil, errors.New("unsupported format: " + v)
+		}
+		p.format = v
+	}
+	if v := q.Get("sh"); v != "" {
+		sh, err := strconv.Atoi(v)
+		if err != nil || sh < 0 || sh > 3 {
+			return nil, errors.New("sh must be an SH degree from 0 to 3")
+		}
+		p.sh = sh
+	}
+	if v := q.Get("max"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, errors.New("max must be a positive number of splats")
+		}
+		p.max = n
+	}
+	if v := q.Get("crop"); v != "" {
+		fields := strings.Split(v, ",")
+		if len(fields) != 6 {
+			return nil, errors.New("crop must be x0,y0,z0,x1,y1,z1")
+		}
+		var box [6]float32
+		for i, f := range fields {
+			x, err := strconv.ParseFloat(strings.TrimSpace(f), 32)
+			if err != nil || math.IsNaN(x) {
+				return nil, errors.New("crop must be x0,y0,z0,x1,y1,z1")
+			}
+			box[i] = float32(x)
+		}
+		p.crop = &spz.Bounds{
+			Min: [3]float32{min(box[0], box[3]), min(box[1], box[4]), min(box[2], box[5])},
+			Max: [3]float32{max(box[0], box[3]), max(box[1], box[4]), max(box[2], box[5])},
+		}
+	}
+	return p, nil
+}
+
+// identity reports whether the file is served as stored
+func (p *transcodeParams) identity() bool {
+	return p.format == "spz" && p.sh < 0 && p.max == 0 && p.crop == nil
+}
+
+// key identifies the transcode of a file version
+func (p *transcodeParams) key(contentHash string) string {
+	s := fmt.Sprintf("%s|format=%s|sh=%d|max=%d", contentHash, p.format, p.sh, p.max)
+	if p.crop != nil {
+		s += fmt.Sprintf("|crop=%v,%v", p.crop.Min, p.crop.Max)
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func (p *transcodeParams) contentType() string {
+	switch p.format {
+	case "ply":
+		return ContentTypePly
+	case "splat":
+		return ContentTypeSplat
+	case "glb":
+		return ContentTypeGlb
+	}
+	return ContentTypeSpz
+}
+
+// transcode decodes a file, applies the crop, decimation and SH degree
+// change, and encodes the result in the requested format
+func transcode(ctx context.Context, bts []byte, p *transcodeParams) ([]byte, error) {
+	data, err := spz.DecodeContext(ctx, bts, &spz.DecodeOptions{SHCodebook: true, Metadata: true})
+	if err != nil {
+		return nil, err
+	}
+
+	if p.crop != nil {
+		kept := data.Data[:0:0]
+		for _, d := range data.Data {
+			if inBounds(d, p.crop) {
+				kept = append(kept, d)
+			}
+		}
+		data.Data = kept
+	}
+	if p.max > 0 && len(data.Data) > p.max {
+		data.Data = decimate(data.Data, p.max)
+	}
+	data.NumPoints = uint32(len(data.Data))
+	// The codebook is not re-encoded
+	data.Flags &^= spz.FlagSHCodebook
+	if p.sh >= 0 && uint8(p.sh) != data.ShDegree {
+		if err := spz.ChangeSHDegreeContext(ctx, data, uint8(p.sh), true); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	switch p.format {
+	case "ply":
+		err = spz.EncodePly(&buf, data)
+	case "splat":
+		err = spz.EncodeSplat(&buf, data)
+	case "glb":
+		err = spz.EncodeGlb(&buf, data)
+	default:
+		var out []byte
+		out, err = spz.EncodeContext(ctx, data, nil)
+		buf.Write(out)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func inBounds(d *spz.SplatData, b *spz.Bounds) bool {
+	p := [3]float32{d.PositionX, d.PositionY, d.PositionZ}
+	for c := range 3 {
+		if p[c] < b.Min[c] || p[c] > b.Max[c] {
+			return false
+		}
+	}
+	return true
+}
+
+// decimate keeps the n splats with the largest opacity-weighted volume,
+// in their original order
+func decimate(splats []*spz.SplatData, n int) []*spz.SplatData {
+	importance := make([]float64, len(splats))
+	order := make([]int, len(splats))
+	for i, d := range splats {
+		importance[i] = float64(d.ColorA) * math.Exp(float64(d.ScaleX)+float64(d.ScaleY)+float64(d.ScaleZ))
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return importance[order[a]] > importance[order[b]] })
+	order = order[:n]
+	sort.Ints(order)
+
+	kept := make([]*spz.SplatData, n)
+	for i, j := range order {
+		kept[i] = splats[j]
+	}
+	return kept
+}
@@ -0,0 +1,243 @@
+package spz
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/flywave/go-spz/internal/cancelcheck"
+)
+
+const (
+	// HistogramBins is the number of bins of each attribute histogram
+	HistogramBins = 16
+	// statsParallelThreshold is the point count above which Stats splits the work
+	statsParallelThreshold = 1 << 16
+	// gaussianBoundsSigma is the ellipsoid extent used for GaussianBounds
+	gaussianBoundsSigma = 3.0
+)
+
+// Bounds represents an axis-aligned bounding box
+type Bounds struct {
+	Min [3]float32 `json:"min"`
+	Max [3]float32 `json:"max"`
+}
+
+func emptyBounds() Bounds {
+	inf := float32(math.Inf(1))
+	return Bounds{Min: [3]float32{inf, inf, inf}, Max: [3]float32{-inf, -inf, -inf}}
+}
+
+func (b *Bounds) extend(lo, hi [3]float32) {
+	for i := range 3 {
+		b.Min[i] = min(b.Min[i], lo[i])
+		b.Max[i] = max(b.Max[i], hi[i])
+	}
+}
+
+func (b *Bounds) merge(o Bounds) {
+	b.extend(o.Min, o.Max)
+}
+
+// Histogram counts values in equally sized bins over [Min, Max]
+type Histogram struct {
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Counts []int   `json:"counts"`
+}
+
+func newHistogram(lo, hi float64) Histogram {
+	return Histogram{Min: lo, Max: hi, Counts: make([]int, HistogramBins)}
+}
+
+func (h *Histogram) add(v float64) {
+	bin := int((v - h.Min) / (h.Max - h.Min) * float64(len(h.Counts)))
+	bin = max(0, min(len(h.Counts)-1, bin))
+	h.Counts[bin]++
+}
+
+func (h *Histogram) merge(o Histogram) {
+	for i := range h.Counts {
+		h.Counts[i] += o.Counts[i]
+	}
+}
+
+// Report holds summary statistics of an SPZ cloud
+type Report struct {
+	NumPoints int    `json:"num_points"`
+	Version   uint32 `json:"version"`
+	ShDegree  uint8  `json:"sh_degree"`
+
+	// Bounds encloses the splat centers
+	Bounds Bounds `json:"bounds"`
+	// GaussianBounds encloses the 3-sigma ellipsoid of every splat
+	GaussianBounds Bounds     `json:"gaussian_bounds"`
+	Centroid       [3]float64 `json:"centroid"`
+
+	Opacity Histogram `json:"opacity"`
+	// Scale is the histogram of the logarithmic scales of all three axes
+	Scale  Histogram `json:"scale"`
+	ColorR Histogram `json:"color_r"`
+	ColorG Histogram `json:"color_g"`
+	ColorB Histogram `json:"color_b"`
+
+	// SHEnergy is the mean sum of squared coefficients per splat for bands 1..ShDegree
+	SHEnergy []float64 `json:"sh_energy"`
+
+	DegenerateQuaternions int `json:"degenerate_quaternions"`
+}
+
+// ToJSON serializes the report to indented JSON
+func (r *Report) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// statsPartial accumulates statistics over a range of splats
+type statsPartial struct {
+	bounds         Bounds
+	gaussianBounds Bounds
+	sum            [3]float64
+	opacity        Histogram
+	scale          Histogram
+	color          [3]Histogram
+	shEnergy       []float64
+	degenerate     int
+}
+
+func newStatsPartial(shDegree uint8) *statsPartial {
+	return &statsPartial{
+		bounds:         emptyBounds(),
+		gaussianBounds: emptyBounds(),
+		opacity:        newHistogram(0, 256),
+		scale:          newHistogram(-10, 6),
+		color:          [3]Histogram{newHistogram(0, 256), newHistogram(0, 256), newHistogram(0, 256)},
+		shEnergy:       make([]float64, shDegree),
+	}
+}
+
+func (p *statsPartial) add(d *SplatData, shDegree uint8) {
+	pos := [3]float32{d.PositionX, d.PositionY, d.PositionZ}
+	p.bounds.extend(pos, pos)
+
+	ext := splatExtent(d, gaussianBoundsSigma)
+	var lo, hi [3]float32
+	for i := range 3 {
+		lo[i] = float32(float64(pos[i]) - ext[i])
+		hi[i] = float32(float64(pos[i]) + ext[i])
+		p.sum[i] += float64(pos[i])
+	}
+	p.gaussianBounds.extend(lo, hi)
+
+	p.opacity.add(float64(d.ColorA))
+	p.scale.add(float64(d.ScaleX))
+	p.scale.add(float64(d.ScaleY))
+	p.scale.add(float64(d.ScaleZ))
+	p.color[0].add(float64(d.ColorR))
+	p.color[1].add(float64(d.ColorG))
+	p.color[2].add(float64(d.ColorB))
+
+	if shDegree > 0 {
+		sh := splatSHBytes(d, shDegree)
+		for band := 1; band <= int(shDegree); band++ {
+			for c := shBandStart[band] * 3; c < shBandStart[band+1]*3; c++ {
+				v := decodeSHByte(sh[c])
+				p.shEnergy[band-1] += v * v
+			}
+		}
+	}
+
+	if _, ok := splatRotation(d); !ok {
+		p.degenerate++
+	}
+}
+
+func (p *statsPartial) merge(o *statsPartial) {
+	p.bounds.merge(o.bounds)
+	p.gaussianBounds.merge(o.gaussianBounds)
+	for i := range 3 {
+		p.sum[i] += o.sum[i]
+		p.color[i].merge(o.color[i])
+	}
+	p.opacity.merge(o.opacity)
+	p.scale.merge(o.scale)
+	for i := range p.shEnergy {
+		p.shEnergy[i] += o.shEnergy[i]
+	}
+	p.degenerate += o.degenerate
+}
+
+// Stats computes bounds, centroid, attribute histograms, SH energy and the
+// number of degenerate quaternions in a single pass. Large clouds are
+// processed in parallel.
+func Stats(data *SpzData) Report {
+	report, _ := StatsContext(context.Background(), data)
+	return report
+}
+
+// StatsContext is Stats with cancellation: it returns the context error once
+// ctx is canceled
+func StatsContext(ctx context.Context, data *SpzData) (Report, error) {
+	shDegree := min(data.ShDegree, 3)
+	rows := data.Data
+	n := len(rows)
+
+	total := newStatsPartial(shDegree)
+	workers := 1
+	if n > statsParallelThreshold {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	partials := make([]*statsPartial, workers)
+	chunk := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for w := range workers {
+		partials[w] = newStatsPartial(shDegree)
+		start, end := w*chunk, min(n, (w+1)*chunk)
+		wg.Add(1)
+		go func(p *statsPartial) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				if cancelcheck.Check(ctx, i-start) != nil {
+					return
+				}
+				p.add(rows[i], shDegree)
+			}
+		}(partials[w])
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return Report{}, err
+	}
+	for _, p := range partials {
+		total.merge(p)
+	}
+
+	report := Report{
+		NumPoints:             n,
+		Version:               data.Version,
+		ShDegree:              shDegree,
+		Bounds:                total.bounds,
+		GaussianBounds:        total.gaussianBounds,
+		Opacity:               total.opacity,
+		Scale:                 total.scale,
+		ColorR:                total.color[0],
+		ColorG:                total.color[1],
+		ColorB:                total.color[2],
+		SHEnergy:              total.shEnergy,
+		DegenerateQuaternions: total.degenerate,
+	}
+	if n == 0 {
+		report.Bounds = Bounds{}
+		report.GaussianBounds = Bounds{}
+		return report, nil
+	}
+	for i := range 3 {
+		report.Centroid[i] = total.sum[i] / float64(n)
+	}
+	for i := range report.SHEnergy {
+		report.SHEnergy[i] /= float64(n)
+	}
+	return report, nil
+}
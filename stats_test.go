@@ -0,0 +1,74 @@
+package spz
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStats tests bounds, centroid and counters on a small cloud
+func TestStats(t *testing.T) {
+	data := &SpzData{
+		Magic:          SPZ_MAGIC,
+		Version:        3,
+		NumPoints:      3,
+		ShDegree:       1,
+		FractionalBits: 12,
+		Data: []*SplatData{
+			{PositionX: -1, PositionY: 0, PositionZ: 2, ScaleX: 0, ScaleY: 0, ScaleZ: 0, RotationW: 255, RotationX: 128, RotationY: 128, RotationZ: 128, ColorA: 255, SH1: []byte{255, 128, 128, 128, 128, 128, 128, 128, 128}},
+			{PositionX: 3, PositionY: -2, PositionZ: 0, RotationW: 255, RotationX: 128, RotationY: 128, RotationZ: 128, ColorA: 0},
+			{PositionX: 1, PositionY: 2, PositionZ: 1, RotationW: 128, RotationX: 128, RotationY: 128, RotationZ: 128, ColorA: 128},
+		},
+	}
+
+	report := Stats(data)
+	assert.Equal(t, 3, report.NumPoints)
+	assert.Equal(t, [3]float32{-1, -2, 0}, report.Bounds.Min)
+	assert.Equal(t, [3]float32{3, 2, 2}, report.Bounds.Max)
+	assert.InDelta(t, 1.0, report.Centroid[0], 1e-9)
+	assert.InDelta(t, 0.0, report.Centroid[1], 1e-9)
+	assert.InDelta(t, 1.0, report.Centroid[2], 1e-9)
+
+	// Unit scale with identity rotation extends by 3 on each side
+	assert.InDelta(t, -4.0, report.GaussianBounds.Min[0], 1e-5)
+	assert.InDelta(t, 5.0, report.GaussianBounds.Max[2], 1e-5)
+
+	assert.Equal(t, 1, report.DegenerateQuaternions)
+	assert.Equal(t, 1, report.Opacity.Counts[0])
+	assert.Equal(t, 1, report.Opacity.Counts[HistogramBins-1])
+	assert.Len(t, report.SHEnergy, 1)
+	assert.InDelta(t, math.Pow(127.0/128.0, 2)/3, report.SHEnergy[0], 1e-9)
+
+	bts, err := report.ToJSON()
+	assert.NoError(t, err)
+	var decoded Report
+	assert.NoError(t, json.Unmarshal(bts, &decoded))
+	assert.Equal(t, report, decoded)
+}
+
+// TestStatsParallel tests that the parallel path matches the serial one
+func TestStatsParallel(t *testing.T) {
+	n := statsParallelThreshold + 1000
+	data := &SpzData{Version: 3, ShDegree: 0, NumPoints: uint32(n)}
+	for i := range n {
+		data.Data = append(data.Data, &SplatData{
+			PositionX: float32(i % 100),
+			PositionY: float32(i % 7),
+			PositionZ: -float32(i % 13),
+			RotationW: 255, RotationX: 128, RotationY: 128, RotationZ: 128,
+			ColorA: uint8(i),
+		})
+	}
+
+	report := Stats(data)
+	assert.Equal(t, [3]float32{0, 0, -12}, report.Bounds.Min)
+	assert.Equal(t, [3]float32{99, 6, 0}, report.Bounds.Max)
+
+	total := 0
+	for _, c := range report.Opacity.Counts {
+		total += c
+	}
+	assert.Equal(t, n, total)
+}
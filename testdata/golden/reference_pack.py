@@ -0,0 +1,271 @@
+#!/usr/bin/env python3
+"""Writes the reference golden files of TestGolden.
+
+packGaussians and unpackGaussians below are a line-by-line port of the
+reference encoder, load-spz.cc of github.com/nianticlabs/spz, including its
+float32 arithmetic. Each reference_v<version>_sh<degree>.spz holds the packed
+cloud; the JSON file next to it holds the input, expressed in go-spz's
+SplatData domain, and the attributes the reference decoder reads back.
+
+Usage: python3 reference_pack.py   (from this directory)
+"""
+
+import gzip
+import json
+import math
+import struct
+
+MAGIC = 0x5053474E
+FRACTIONAL_BITS = 12
+COLOR_SCALE = 0.15
+SH_C0 = 0.28209479177387814
+SQRT1_2 = 0.7071067811865476
+SH_DIM = {0: 0, 1: 3, 2: 8, 3: 15}
+
+
+def f32(x):
+    """Rounds x to float32, as after every float operation in C++."""
+    return struct.unpack("<f", struct.pack("<f", x))[0]
+
+
+def cround(x):
+    """std::round: halfway cases away from zero."""
+    return math.copysign(math.floor(abs(x) + 0.5), x)
+
+
+def to_uint8(x):
+    return int(min(max(cround(x), 0.0), 255.0))
+
+
+def sigmoid(x):
+    return f32(1 / f32(1 + f32(math.exp(f32(-x)))))
+
+
+def inv_sigmoid(x):
+    return f32(math.log(f32(x / f32(1 - x))))
+
+
+def normalized(q):
+    norm = f32(math.sqrt(f32(f32(f32(q[0] * q[0]) + f32(q[1] * q[1])) + f32(f32(q[2] * q[2]) + f32(q[3] * q[3])))))
+    return [f32(c / norm) for c in q]
+
+
+def quantize_sh(x, bucket):
+    q = int(cround(f32(x * 128.0)) + 128.0)
+    q = (q + bucket // 2) // bucket * bucket
+    return min(max(q, 0), 255)
+
+
+def pack_smallest_three(q):
+    largest = 0
+    for i in range(1, 4):
+        if abs(q[i]) > abs(q[largest]):
+            largest = i
+    negate = 1 if q[largest] < 0 else 0
+    comp = largest
+    for i in range(4):
+        if i != largest:
+            negbit = (1 if q[i] < 0 else 0) ^ negate
+            mag = int(float((1 << 9) - 1) * (abs(q[i]) / SQRT1_2) + 0.5)
+            comp = (comp << 10) | (negbit << 9) | mag
+    return list(struct.pack("<I", comp))
+
+
+def unpack_smallest_three(r):
+    comp = struct.unpack("<I", bytes(r))[0]
+    mask = (1 << 9) - 1
+    largest = comp >> 30
+    rotation = [0.0] * 4
+    sum_squares = 0.0
+    for i in range(3, -1, -1):
+        if i != largest:
+            mag = comp & mask
+            negbit = (comp >> 9) & 1
+            comp >>= 10
+            rotation[i] = f32(SQRT1_2 * mag / float(mask))
+            if negbit == 1:
+                rotation[i] = -rotation[i]
+            sum_squares = f32(sum_squares + f32(rotation[i] * rotation[i]))
+    rotation[largest] = f32(math.sqrt(f32(1.0 - sum_squares)))
+    return rotation
+
+
+def pack_gaussians(g, version):
+    """Port of packGaussians: g holds float attributes, rotations in x, y, z, w order."""
+    n = len(g["positions"]) // 3
+    out = struct.pack("<IIIBBBB", MAGIC, version, n, g["sh_degree"], FRACTIONAL_BITS, 0, 0)
+
+    scale = float(1 << FRACTIONAL_BITS)
+    positions = []
+    for p in g["positions"]:
+        fixed32 = int(cround(f32(p * scale)))
+        positions += [fixed32 & 0xFF, (fixed32 >> 8) & 0xFF, (fixed32 >> 16) & 0xFF]
+    scales = [to_uint8(f32(f32(s + 10.0) * 16.0)) for s in g["scales"]]
+    rotations = []
+    for i in range(n):
+        q = normalized(g["rotations"][i * 4 : i * 4 + 4])
+        if version >= 3:
+            rotations += pack_smallest_three(q)
+        else:
+            sign = -127.5 if q[3] < 0 else 127.5
+            q = [f32(f32(c * sign) + 127.5) for c in q]
+            rotations += [to_uint8(q[0]), to_uint8(q[1]), to_uint8(q[2])]
+    alphas = [to_uint8(f32(sigmoid(a) * 255.0)) for a in g["alphas"]]
+    color_scale = f32(f32(COLOR_SCALE) * 255.0)
+    colors = [to_uint8(f32(f32(c * color_scale) + 127.5)) for c in g["colors"]]
+    sh = []
+    per_point = SH_DIM[g["sh_degree"]] * 3
+    for i in range(n):
+        for j in range(per_point):
+            sh.append(quantize_sh(g["sh"][i * per_point + j], 1 << (8 - (5 if j < 9 else 4))))
+
+    return out + bytes(positions + alphas + colors + scales + rotations + sh)
+
+
+def unpack_gaussians(payload):
+    """Port of unpackGaussians, rotations returned in x, y, z, w order."""
+    _, version, n, degree, bits, _, _ = struct.unpack("<IIIBBBB", payload[:16])
+    data = payload[16:]
+    rsize = 4 if version >= 3 else 3
+    dim = SH_DIM[degree] * 3
+    sections = {}
+    offset = 0
+    for name, size in [("positions", 9), ("alphas", 1), ("colors", 3), ("scales", 3), ("rotations", rsize), ("sh", dim)]:
+        sections[name] = data[offset : offset + n * size]
+        offset += n * size
+
+    g = {"positions": [], "scales": [], "rotations": [], "alphas": [], "colors": [], "sh": []}
+    scale = 1.0 / (1 << bits)
+    p = sections["positions"]
+    for i in range(n * 3):
+        fixed32 = p[i * 3] | p[i * 3 + 1] << 8 | p[i * 3 + 2] << 16
+        if fixed32 & 0x800000:
+            fixed32 -= 1 << 24
+        g["positions"].append(f32(fixed32 * scale))
+    g["scales"] = [f32(f32(s / 16.0) - 10.0) for s in sections["scales"]]
+    r = sections["rotations"]
+    for i in range(n):
+        if version >= 3:
+            g["rotations"] += unpack_smallest_three(r[i * 4 : i * 4 + 4])
+        else:
+            xyz = [f32(f32(c * f32(1.0 / 127.5)) - 1.0) for c in r[i * 3 : i * 3 + 3]]
+            w = f32(math.sqrt(max(0.0, f32(1.0 - f32(f32(xyz[0] * xyz[0]) + f32(xyz[1] * xyz[1]) + f32(xyz[2] * xyz[2]))))))
+            g["rotations"] += xyz + [w]
+    g["alphas"] = [inv_sigmoid(f32(a / 255.0)) for a in sections["alphas"]]
+    g["colors"] = [f32(f32(f32(c / 255.0) - 0.5) / f32(COLOR_SCALE)) for c in sections["colors"]]
+    g["sh"] = [f32(f32(s - 128.0) / 128.0) for s in sections["sh"]]
+    return g
+
+
+def splat_input(i, degree):
+    """A splat in go-spz's SplatData domain, covering negative and large
+    positions, the scale range, all four largest rotation components and every
+    SH band."""
+    rotations = [[255, 128, 128, 128], [140, 250, 120, 128], [100, 128, 30, 150], [128, 160, 128, 5]]
+    s = {
+        "position": [f32(i * 1.25 - 4), f32(i * i * 30.5), f32(-i * 0.001)],
+        "scale": [f32(i * 1.5 - 9), f32(-i * 0.3), f32(2 - i)],
+        "rotation": rotations[i % 4],
+        "color": [100 + i * 8, 140 - i * 4, 128 + i * 15, 1 + i * 36],
+        "sh": [],
+    }
+    if degree > 0:
+        s["sh"] = [(i * 37 + j * 53) % 256 for j in range(SH_DIM[degree] * 3)]
+    return s
+
+
+def to_reference(splats, degree):
+    """Converts SplatData values to the float attributes the reference packs:
+    DC colors, opacity logits, x, y, z, w rotations and SH coefficients."""
+    g = {"sh_degree": degree, "positions": [], "scales": [], "rotations": [], "alphas": [], "colors": [], "sh": []}
+    for s in splats:
+        g["positions"] += s["position"]
+        g["scales"] += s["scale"]
+        w, x, y, z = s["rotation"]
+        g["rotations"] += [f32(v / 128.0 - 1.0) for v in (x, y, z, w)]
+        a = s["color"][3]
+        g["alphas"].append(f32(math.log(a / (255.0 - a))))
+        g["colors"] += [f32((c / 255.0 - 0.5) / SH_C0) for c in s["color"][:3]]
+        g["sh"] += [f32((b - 128) / 128.0) for b in s["sh"]]
+    return g
+
+
+def rotation_bytes(q):
+    """Stores x, y, z, w rotation components as the w, x, y, z bytes of
+    go-spz's SplatData, each byte meaning v / 128 - 1."""
+    x, y, z, w = q
+    return [to_uint8(c * 128.0 + 128.0) for c in (w, x, y, z)]
+
+
+def reencoded_rotations(g, version):
+    """Packs the SplatData rotation bytes of reference-decoded attributes
+    again: the rotation section go-spz writes when re-encoding a decoded
+    file."""
+    n = len(g["alphas"])
+    rotations = []
+    for i in range(n):
+        w, x, y, z = rotation_bytes(g["rotations"][i * 4 : i * 4 + 4])
+        rotations += [f32(v / 128.0 - 1.0) for v in (x, y, z, w)]
+    packed = pack_gaussians(
+        {
+            "sh_degree": 0,
+            "positions": [0.0] * 3 * n,
+            "scales": [0.0] * 3 * n,
+            "rotations": rotations,
+            "alphas": [0.0] * n,
+            "colors": [0.0] * 3 * n,
+            "sh": [],
+        },
+        version,
+    )
+    return list(packed[16 + n * (9 + 1 + 3 + 3) :])
+
+
+def to_expected(g, degree):
+    """Converts reference-decoded attributes to the values TestGolden checks:
+    opacity and display color on [0, 1], normalized w, x, y, z rotations and
+    the SplatData rotation bytes."""
+    out = []
+    per_point = SH_DIM[degree] * 3
+    for i in range(len(g["alphas"])):
+        x, y, z, w = g["rotations"][i * 4 : i * 4 + 4]
+        norm = math.sqrt(w * w + x * x + y * y + z * z)
+        out.append(
+            {
+                "position": g["positions"][i * 3 : i * 3 + 3],
+                "scale": g["scales"][i * 3 : i * 3 + 3],
+                "rotation": [w / norm, x / norm, y / norm, z / norm],
+                "rotation_bytes": rotation_bytes(g["rotations"][i * 4 : i * 4 + 4]),
+                "alpha": 1 / (1 + math.exp(-g["alphas"][i])),
+                "color": [c * SH_C0 + 0.5 for c in g["colors"][i * 3 : i * 3 + 3]],
+                "sh": g["sh"][i * per_point : (i + 1) * per_point],
+            }
+        )
+    return out
+
+
+def main():
+    for version in (2, 3):
+        for degree in range(4):
+            name = "reference_v%d_sh%d" % (version, degree)
+            splats = [splat_input(i, degree) for i in range(8)]
+            payload = pack_gaussians(to_reference(splats, degree), version)
+            with open(name + ".spz", "wb") as f:
+                f.write(gzip.compress(payload, mtime=0))
+            decoded = unpack_gaussians(payload)
+            doc = {
+                "source": "reference_pack.py, a port of load-spz.cc",
+                "version": version,
+                "sh_degree": degree,
+                "num_points": len(splats),
+                "input": splats,
+                "splats": to_expected(decoded, degree),
+                "reencoded_rotations": reencoded_rotations(decoded, version),
+            }
+            with open(name + ".json", "w") as f:
+                json.dump(doc, f, indent=2)
+                f.write("\n")
+
+
+if __name__ == "__main__":
+    main()
@@ -6,8 +6,13 @@ import (
 )
 
 const (
-	SH_C0       = 0.28209479177387814
-	COLOR_SCALE = 2.0
+	SH_C0 = 0.28209479177387814
+	// COLOR_SCALE maps the DC color coefficients to the stored bytes, as in
+	// the reference encoder
+	COLOR_SCALE = 0.15
+	// legacyColorScale is the color scale of go-spz releases before the
+	// reference encoding
+	legacyColorScale = 2.0
 )
 
 func clipFloat32(v float64) float32 {
@@ -45,6 +50,16 @@ func spzDecodePosition(bts []byte, fractionalBits uint8) float32 {
 }
 
 func spzDecodeRotations(rx uint8, ry uint8, rz uint8) (uint8, uint8, uint8, uint8) {
+	r1 := float64(rx)/127.5 - 1.0
+	r2 := float64(ry)/127.5 - 1.0
+	r3 := float64(rz)/127.5 - 1.0
+	r0 := math.Sqrt(math.Max(0.0, 1.0-(r1*r1+r2*r2+r3*r3)))
+	return clipUint8Round(r0*128.0 + 128.0), clipUint8Round(r1*128.0 + 128.0), clipUint8Round(r2*128.0 + 128.0), clipUint8Round(r3*128.0 + 128.0)
+}
+
+// spzDecodeRotationsLegacy decodes a version 2 rotation as go-spz releases
+// before the reference encoding did, truncating instead of rounding
+func spzDecodeRotationsLegacy(rx uint8, ry uint8, rz uint8) (uint8, uint8, uint8, uint8) {
 	r1 := float64(rx)/127.5 - 1.0
 	r2 := float64(ry)/127.5 - 1.0
 	r3 := float64(rz)/127.5 - 1.0
@@ -52,7 +67,26 @@ func spzDecodeRotations(rx uint8, ry uint8, rz uint8) (uint8, uint8, uint8, uint
 	return clipUint8(r0*128.0 + 128.0), clipUint8(r1*128.0 + 128.0), clipUint8(r2*128.0 + 128.0), clipUint8(r3*128.0 + 128.0)
 }
 
+// spzDecodeRotationsV3 decodes a smallest-three rotation. Component indices
+// follow the x, y, z, w order of the reference encoder.
 func spzDecodeRotationsV3(bs []byte) (uint8, uint8, uint8, uint8) {
+	rotation := unpackSmallestThree(bs)
+	r0, r1, r2, r3 := rotation[3], rotation[0], rotation[1], rotation[2]
+	return clipUint8Round(r0*128.0 + 128.0), clipUint8Round(r1*128.0 + 128.0), clipUint8Round(r2*128.0 + 128.0), clipUint8Round(r3*128.0 + 128.0)
+}
+
+// spzDecodeRotationsV3Legacy decodes a smallest-three rotation written by
+// go-spz releases before the reference encoding, whose component indices
+// follow the w, x, y, z order
+func spzDecodeRotationsV3Legacy(bs []byte) (uint8, uint8, uint8, uint8) {
+	rotation := unpackSmallestThree(bs)
+	r0, r1, r2, r3 := rotation[0], rotation[1], rotation[2], rotation[3]
+	return clipUint8(r0*128.0 + 128.0), clipUint8(r1*128.0 + 128.0), clipUint8(r2*128.0 + 128.0), clipUint8(r3*128.0 + 128.0)
+}
+
+// unpackSmallestThree unpacks the four components of a smallest-three
+// rotation in their stored order
+func unpackSmallestThree(bs []byte) []float64 {
 	comp := binary.LittleEndian.Uint32(bs)
 	index := int(comp >> 30)
 	remaining := comp
@@ -75,20 +109,23 @@ func spzDecodeRotationsV3(bs []byte) (uint8, uint8, uint8, uint8) {
 	}
 
 	rotation[index] = math.Sqrt(math.Max(1.0-sumSquares, 0))
-
-	r0, r1, r2, r3 := rotation[0], rotation[1], rotation[2], rotation[3]
-	return clipUint8(r0*128.0 + 128.0), clipUint8(r1*128.0 + 128.0), clipUint8(r2*128.0 + 128.0), clipUint8(r3*128.0 + 128.0)
+	return rotation
 }
 
 // spzDecodeColor decodes color value (inverse of spzEncodeColor)
 func spzDecodeColor(val uint8) uint8 {
-	const SH_C0 = 0.28209479177387814
-	const COLOR_SCALE = 2.0
 	// Decode from spz format
 	fColor := (float64(val)/255.0 - 0.5) / COLOR_SCALE
 	// Restore original color
 	original := (fColor*SH_C0 + 0.5) * 255.0
-	return clipUint8(original)
+	return clipUint8Round(original)
+}
+
+// spzDecodeColorLegacy decodes a color written by go-spz releases before
+// the reference color scale
+func spzDecodeColorLegacy(val uint8) uint8 {
+	fColor := (float64(val)/255.0 - 0.5) / legacyColorScale
+	return clipUint8((fColor*SH_C0 + 0.5) * 255.0)
 }
 
 // spzDecodeSH1 decodes SH1 values (inverse of spzEncodeSH1)
@@ -115,9 +152,32 @@ func uint32ToBytes(val uint32) []byte {
 	return bts
 }
 
+const (
+	// positionFractionalBits is the number of fractional bits of the 24-bit
+	// fixed-point positions; it is the only value the format allows
+	positionFractionalBits = 12
+	// minFixedPosition and maxFixedPosition bound the 24-bit fixed-point
+	// position values
+	minFixedPosition = -1 << 23
+	maxFixedPosition = 1<<23 - 1
+)
+
+// positionFixed returns the fixed-point value a position is encoded as,
+// before it is truncated to 24 bits
+func positionFixed(f float64) float64 {
+	return math.Round(f * (1 << positionFractionalBits))
+}
+
+// positionOverflows reports whether a position lies outside the range the
+// 24-bit fixed-point encoding represents
+func positionOverflows(f float64) bool {
+	fixed := positionFixed(f)
+	return fixed < minFixedPosition || fixed > maxFixedPosition
+}
+
 // encodeFloat32ToBytes3 converts float32 to 3 bytes (24-bit fixed point)
 func encodeFloat32ToBytes3(f float32) []byte {
-	fixed32 := int32(math.Round(float64(f) * 4096))
+	fixed32 := int32(positionFixed(float64(f)))
 
 	return []byte{
 		byte(fixed32 & 0xFF),
@@ -159,12 +219,13 @@ func spzEncodeRotations(rw uint8, rx uint8, ry uint8, rz uint8) []byte {
 	}
 }
 
-// spzEncodeRotationsV3 encodes rotation for version 3
+// spzEncodeRotationsV3 encodes rotation for version 3. Component indices
+// follow the x, y, z, w order of the reference encoder.
 func spzEncodeRotationsV3(rw uint8, rx uint8, ry uint8, rz uint8) []byte {
-	r0 := float64(rw)/128.0 - 1.0
-	r1 := float64(rx)/128.0 - 1.0
-	r2 := float64(ry)/128.0 - 1.0
-	r3 := float64(rz)/128.0 - 1.0
+	r0 := float64(rx)/128.0 - 1.0
+	r1 := float64(ry)/128.0 - 1.0
+	r2 := float64(rz)/128.0 - 1.0
+	r3 := float64(rw)/128.0 - 1.0
 	qlen := math.Sqrt(r0*r0 + r1*r1 + r2*r2 + r3*r3)
 	rotation := []float64{r0 / qlen, r1 / qlen, r2 / qlen, r3 / qlen}
 
@@ -174,15 +235,15 @@ func spzEncodeRotationsV3(rw uint8, rx uint8, ry uint8, rz uint8) []byte {
 			index = i
 		}
 	}
-	if rotation[index] < 0 {
-		rotation[0], rotation[1], rotation[2], rotation[3] = -rotation[0], -rotation[1], -rotation[2], -rotation[3]
-	}
+	// The largest component is stored positive by flipping the other signs,
+	// zeros included, as the reference encoder does
+	negate := rotation[index] < 0
 
 	remaining := uint32(index)
 	for i := 0; i < 4; i++ {
 		if i != index {
 			signBit := uint32(0)
-			if rotation[i] < 0 {
+			if (rotation[i] < 0) != negate {
 				signBit = 1
 			}
 
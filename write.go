@@ -1,24 +1,148 @@
 package spz
 
 import (
+	"context"
 	"os"
+	"strconv"
+
+	"github.com/flywave/go-spz/internal/cancelcheck"
 )
 
 // WriteSpz writes SPZ data to file
 func WriteSpz(spzFile string, spzData *SpzData) error {
+	return WriteSpzWithOptions(spzFile, spzData, nil)
+}
+
+// WriteSpzWithCompressor writes SPZ data to file using the given codec
+func WriteSpzWithCompressor(spzFile string, spzData *SpzData, c Compressor) error {
+	return WriteSpzWithOptions(spzFile, spzData, &EncodeOptions{Compressor: c})
+}
+
+// WriteSpzWithOptions writes SPZ data to file with optional encoding features
+func WriteSpzWithOptions(spzFile string, spzData *SpzData, opts *EncodeOptions) error {
+	return WriteSpzContext(context.Background(), spzFile, spzData, opts)
+}
+
+// WriteSpzContext writes SPZ data to file, stopping with the context error
+// when ctx is canceled. Nothing is written if encoding is canceled.
+func WriteSpzContext(ctx context.Context, spzFile string, spzData *SpzData, opts *EncodeOptions) error {
+	compressedDatas, err := EncodeContext(ctx, spzData, opts)
+	if err != nil {
+		return err
+	}
+
 	file, err := os.Create(spzFile)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	bts := make([]byte, 0)
-	bts = append(bts, spzData.ToBytes()...)
+	// Write to file
+	_, err = file.Write(compressedDatas)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// EncodeOptions controls optional encoding features
+type EncodeOptions struct {
+	// Compressor compresses the payload; nil selects DefaultCompressor
+	Compressor Compressor
+	// SHCodebookSize enables the experimental SH codebook mode with a palette
+	// of at most this many entries (up to MaxSHCodebookSize)
+	SHCodebookSize int
+	// SHCodebookIterations is the number of k-means iterations (0 selects a default)
+	SHCodebookIterations int
+	// Checksum embeds a CRC-32 and SHA-256 of the uncompressed payload in the
+	// metadata extension
+	Checksum bool
+}
+
+// Encode encodes SPZ data with the default gzip codec
+func Encode(spzData *SpzData) ([]byte, error) {
+	return EncodeWithOptions(spzData, nil)
+}
+
+// EncodeWithCompressor encodes SPZ data and compresses it with the given codec
+func EncodeWithCompressor(spzData *SpzData, c Compressor) ([]byte, error) {
+	return EncodeWithOptions(spzData, &EncodeOptions{Compressor: c})
+}
+
+// EncodeWithOptions encodes SPZ data with optional encoding features
+func EncodeWithOptions(spzData *SpzData, opts *EncodeOptions) ([]byte, error) {
+	return EncodeContext(context.Background(), spzData, opts)
+}
+
+// EncodeContext encodes SPZ data with optional encoding features. It checks
+// ctx periodically while encoding splats and between k-means iterations, and
+// returns the context error once ctx is canceled.
+func EncodeContext(ctx context.Context, spzData *SpzData, opts *EncodeOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &EncodeOptions{}
+	}
+	c := opts.Compressor
+	if c == nil {
+		c = DefaultCompressor
+	}
 
+	payload, err := encodeSpzPayload(ctx, spzData)
+	if err != nil {
+		return nil, err
+	}
+	if opts.SHCodebookSize > 0 && spzData.ShDegree > 0 {
+		payload, err = encodeSHCodebook(ctx, payload, spzData, opts.SHCodebookSize, opts.SHCodebookIterations)
+		if err != nil {
+			return nil, err
+		}
+	}
+	metadata := spzData.Metadata
+	if opts.Checksum {
+		metadata, err = withChecksum(metadata, payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(metadata) > 0 {
+		ext, err := encodeMetadata(metadata)
+		if err != nil {
+			return nil, err
+		}
+		payload = append(payload, ext...)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Compress(payload)
+}
+
+// encodeSpzPayload encodes the uncompressed header and data sections. The
+// header counts the splats of spzData.Data, whatever NumPoints holds.
+func encodeSpzPayload(ctx context.Context, spzData *SpzData) ([]byte, error) {
 	rows := spzData.Data
+	// Only encodeSHCodebook marks the header, after replacing the SH section
+	h := *spzData
+	h.NumPoints = uint32(len(rows))
+	h.Flags &^= FlagSHCodebook
+	bts := make([]byte, 0)
+	bts = append(bts, h.ToBytes()...)
+
+	if spzData.ShDegree > 3 {
+		return nil, &SpzError{"Unsupported SH degree: " + strconv.Itoa(int(spzData.ShDegree))}
+	}
+	for _, d := range rows {
+		if d == nil {
+			return nil, &SpzError{"Invalid SPZ data: nil splat"}
+		}
+	}
 
 	// Encode positions
 	for i := range rows {
+		if err := cancelcheck.Check(ctx, i); err != nil {
+			return nil, err
+		}
 		bts = append(bts, spzEncodePosition(rows[i].PositionX)...)
 		bts = append(bts, spzEncodePosition(rows[i].PositionY)...)
 		bts = append(bts, spzEncodePosition(rows[i].PositionZ)...)
@@ -48,94 +172,21 @@ func WriteSpz(spzFile string, spzData *SpzData) error {
 		}
 	}
 
-	// Encode SH data
-	switch spzData.ShDegree {
-	case 1:
+	// Encode SH data, the first 9 coefficients at the band 1 precision
+	if spzData.ShDegree > 0 {
 		for i := range rows {
-			if len(rows[i].SH1) > 0 {
-				for n := range 9 {
-					bts = append(bts, spzEncodeSH1(rows[i].SH1[n]))
-				}
-			} else if len(rows[i].SH2) > 0 {
-				for n := range 9 {
-					bts = append(bts, spzEncodeSH1(rows[i].SH2[n]))
-				}
-			} else {
-				for range 9 {
-					bts = append(bts, encodeSplatSH(0.0))
-				}
+			if err := cancelcheck.Check(ctx, i); err != nil {
+				return nil, err
 			}
-		}
-	case 2:
-		for i := range rows {
-			if len(rows[i].SH1) > 0 {
-				for n := range 9 {
-					bts = append(bts, spzEncodeSH1(rows[i].SH1[n]))
-				}
-				for range 15 {
-					bts = append(bts, encodeSplatSH(0.0))
-				}
-			} else if len(rows[i].SH2) > 0 {
-				for n := range 9 {
-					bts = append(bts, spzEncodeSH1(rows[i].SH2[n]))
-				}
-				for j := 9; j < 24; j++ {
-					bts = append(bts, spzEncodeSH23(rows[i].SH2[j]))
-				}
-			} else {
-				for range 24 {
-					bts = append(bts, encodeSplatSH(0.0))
-				}
-			}
-		}
-	case 3:
-		for i := range rows {
-			if len(rows[i].SH3) > 0 {
-				for j := range 9 {
-					bts = append(bts, spzEncodeSH1(rows[i].SH2[j]))
-				}
-				for j := 9; j < 24; j++ {
-					bts = append(bts, spzEncodeSH23(rows[i].SH2[j]))
-				}
-				for j := range 21 {
-					bts = append(bts, spzEncodeSH23(rows[i].SH3[j]))
-				}
-			} else if len(rows[i].SH2) > 0 {
-				for j := range 9 {
-					bts = append(bts, spzEncodeSH1(rows[i].SH2[j]))
-				}
-				for j := 9; j < 24; j++ {
-					bts = append(bts, spzEncodeSH23(rows[i].SH2[j]))
-				}
-				for range 21 {
-					bts = append(bts, encodeSplatSH(0.0))
-				}
-			} else if len(rows[i].SH1) > 0 {
-				for n := range 9 {
-					bts = append(bts, spzEncodeSH1(rows[i].SH1[n]))
-				}
-				for range 36 {
-					bts = append(bts, encodeSplatSH(0.0))
-				}
-			} else {
-				for range 45 {
-					bts = append(bts, encodeSplatSH(0.0))
+			for j, v := range splatSHBytes(rows[i], spzData.ShDegree) {
+				if j < 9 {
+					bts = append(bts, spzEncodeSH1(v))
+				} else {
+					bts = append(bts, spzEncodeSH23(v))
 				}
 			}
 		}
 	}
 
-	// Compress with gzip
-	gzipDatas, err := compressGzip(bts)
-	if err != nil {
-		return err
-	}
-
-	// Write to file
-	_, err = file.Write(gzipDatas)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return bts, nil
 }